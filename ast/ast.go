@@ -1,10 +1,17 @@
 package ast
 
-import "bytes"
+import (
+	"bytes"
+	"monkey/token"
+)
 
+// Node is implemented by every node in the ast
+// Pos and End describe the span of source the node was parsed from
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the first char of the node
+	End() token.Position // position immediately after the node
 }
 
 type Statement interface {
@@ -19,6 +26,7 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Comments   []token.Comment // every comment in source order, only set if the lexer keeps comments
 }
 
 func (p *Program) TokenLiteral() string {
@@ -28,6 +36,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -36,3 +58,23 @@ func (p *Program) String() string {
 	}
 	return out.String()
 }
+
+// after returns the position following the single char at p
+// used for spans closed by a delimiter such as ")" or "}"
+func after(p token.Position) token.Position {
+	if !p.IsValid() {
+		return p
+	}
+	p.Column++
+	p.Offset++
+	return p
+}
+
+// endOf returns the end of n, falling back to the given position if n is missing
+// nodes can be incomplete when the parser ran into errors
+func endOf(n Node, fallback token.Position) token.Position {
+	if n == nil {
+		return fallback
+	}
+	return n.End()
+}
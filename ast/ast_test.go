@@ -26,3 +26,21 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestHashLiteralString(t *testing.T) {
+	str := func(s string) Expression {
+		return &StringLiteral{Token: token.Token{Type: token.STRING, Literal: s}, Value: s}
+	}
+	keys := []Expression{str("b"), str("a"), str("c"), str("d")}
+	hash := &HashLiteral{Pairs: map[Expression]Expression{}, Keys: keys}
+	for _, k := range keys {
+		hash.Pairs[k] = k
+	}
+
+	// pairs print in source order, not in the random order of the map
+	for i := 0; i < 10; i++ {
+		if hash.String() != "{b:b, a:a, c:c, d:d}" {
+			t.Fatalf("hash.String() wrong. got=%q", hash.String())
+		}
+	}
+}
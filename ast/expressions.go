@@ -15,6 +15,8 @@ type Identifier struct {
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 
 type IntegerLiteral struct {
 	Token token.Token // INT
@@ -24,6 +26,19 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token // FLOAT
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token // e.g. "!", "-"
@@ -33,6 +48,8 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position  { return endOf(pe.Right, pe.Token.End) }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -49,6 +66,13 @@ type InfixExpression struct {
 
 func (oe *InfixExpression) expressionNode()      {}
 func (oe *InfixExpression) TokenLiteral() string { return oe.Token.Literal }
+func (oe *InfixExpression) Pos() token.Position {
+	if oe.Left == nil {
+		return oe.Token.Pos
+	}
+	return oe.Left.Pos()
+}
+func (oe *InfixExpression) End() token.Position { return endOf(oe.Right, oe.Token.End) }
 func (oe *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -64,6 +88,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type IfExpression struct {
 	Token       token.Token
@@ -74,6 +100,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return endOf(ie.Condition, ie.Token.End)
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString(fmt.Sprintf("if%s %s", ie.Condition.String(), ie.Consequence.String()))
@@ -93,6 +129,13 @@ type FuncLiteral struct {
 
 func (fl *FuncLiteral) expressionNode()      {}
 func (fl *FuncLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FuncLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FuncLiteral) End() token.Position {
+	if fl.Body == nil {
+		return fl.Token.End
+	}
+	return fl.Body.End()
+}
 func (fl *FuncLiteral) String() string {
 	var out bytes.Buffer
 
@@ -112,10 +155,13 @@ type CallExpression struct {
 	Token     token.Token // "(" is the infix operator
 	Function  Expression  // identifier or func literal
 	Arguments []Expression
+	Rparen    token.Position // position of the closing ")"
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return after(ce.Rparen) }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -135,14 +181,43 @@ type StringLiteral struct {
 func (s *StringLiteral) expressionNode()      {}
 func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
 func (s *StringLiteral) String() string       { return s.Value }
+func (s *StringLiteral) Pos() token.Position  { return s.Token.Pos }
+func (s *StringLiteral) End() token.Position  { return s.Token.End }
+
+// InterpolatedString is a "...${exp}..." literal
+// Strings holds the text around the expressions, it has one element more than Exprs
+type InterpolatedString struct {
+	Token   token.Token // the INTERP_START token
+	Strings []string
+	Exprs   []Expression
+	Rquote  token.Position // position of the closing "
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) End() token.Position  { return after(is.Rquote) }
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+	for i, s := range is.Strings {
+		out.WriteString(s)
+		if i < len(is.Exprs) {
+			out.WriteString("${" + is.Exprs[i].String() + "}")
+		}
+	}
+	return out.String()
+}
 
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	Rbracket token.Position // position of the closing "]"
 }
 
 func (arr *ArrayLiteral) expressionNode()      {}
 func (arr *ArrayLiteral) TokenLiteral() string { return arr.Token.Literal }
+func (arr *ArrayLiteral) Pos() token.Position  { return arr.Token.Pos }
+func (arr *ArrayLiteral) End() token.Position  { return after(arr.Rbracket) }
 func (arr *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -156,13 +231,16 @@ func (arr *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Position // position of the closing "]"
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return after(ie.Rbracket) }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -173,21 +251,59 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// MemberExpression selects the member of a module by name, e.g. json.parse
+// for other values a.b is the same as a["b"]
+type MemberExpression struct {
+	Token  token.Token // the "."
+	Left   Expression
+	Member *Identifier
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Pos() token.Position  { return me.Left.Pos() }
+func (me *MemberExpression) End() token.Position  { return me.Member.End() }
+func (me *MemberExpression) String() string {
+	return "(" + me.Left.String() + "." + me.Member.String() + ")"
+}
+
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token
+	Pairs  map[Expression]Expression
+	Keys   []Expression   // the keys of Pairs in source order
+	Rbrace token.Position // position of the closing "}"
 }
 
 func (h *HashLiteral) expressionNode()      {}
 func (h *HashLiteral) TokenLiteral() string { return h.Token.Literal }
+func (h *HashLiteral) Pos() token.Position  { return h.Token.Pos }
+func (h *HashLiteral) End() token.Position  { return after(h.Rbrace) }
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for k, v := range h.Pairs {
-		pairs = append(pairs, k.String()+":"+v.String())
+	for _, k := range h.Keys {
+		pairs = append(pairs, k.String()+":"+h.Pairs[k].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
 	out.WriteString("}")
 	return out.String()
 }
+
+type AssignExpression struct {
+	Token    token.Token // the assignment operator, e.g. "=", "+="
+	Target   Expression  // identifier or index expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) End() token.Position  { return endOf(ae.Value, ae.Token.End) }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(fmt.Sprintf("(%s %s %s)", ae.Target.String(), ae.Operator, ae.Value.String()))
+	return out.String()
+}
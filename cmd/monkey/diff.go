@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// edit is a line of a diff, op is ' ', '-' or '+'
+type edit struct {
+	op   byte
+	line string
+}
+
+// unifiedDiff returns the changes from a to b in unified format, empty if they are equal
+func unifiedDiff(name string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", name, name)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		// a hunk starts diffContext lines before the change & extends until diffContext*2
+		// unchanged lines separate it from the next one
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end, equal := i, 0
+		for end < len(edits) && equal <= diffContext*2 {
+			if edits[end].op == ' ' {
+				equal++
+			} else {
+				equal = 0
+			}
+			end++
+		}
+		end -= equal - diffContext
+		if end > len(edits) {
+			end = len(edits)
+		}
+		writeHunk(&out, edits, start, end)
+		i = end
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, edits []edit, start, end int) {
+	// line numbers of the hunk in a & b are one past the lines before it
+	aLine, bLine := 1, 1
+	for _, e := range edits[:start] {
+		if e.op != '+' {
+			aLine++
+		}
+		if e.op != '-' {
+			bLine++
+		}
+	}
+	aLen, bLen := 0, 0
+	for _, e := range edits[start:end] {
+		if e.op != '+' {
+			aLen++
+		}
+		if e.op != '-' {
+			bLen++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aLine, aLen, bLine, bLen)
+	for _, e := range edits[start:end] {
+		fmt.Fprintf(out, "%c%s\n", e.op, e.line)
+	}
+}
+
+func splitLines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines returns the edits turning a into b from their longest common subsequence
+func diffLines(a, b []string) []edit {
+	// lcs[i][j] is the length of the lcs of a[i:] & b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	return edits
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"monkey/format"
+	"monkey/lexer"
+	"os"
+	"path/filepath"
+)
+
+// sourceExt is the extension of the files formatted when walking a directory
+const sourceExt = ".mk"
+
+// fmtOptions select what fmt does with the formatted source
+type fmtOptions struct {
+	write bool // overwrite files that are not formatted
+	diff  bool // print a diff of the changes
+}
+
+// fmtFiles formats the files & directories in paths, stdin if there are none
+// without -w or -d the formatted source is printed to stdout
+func fmtFiles(paths []string, opts fmtOptions, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(paths) == 0 {
+		if opts.write {
+			fmt.Fprintf(stderr, "monkey fmt: cannot use -w with stdin\n")
+			return exitUsage
+		}
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey: %s\n", err)
+			return exitIOError
+		}
+		_, code := fmtSource("<stdin>", src, opts, stdout, stderr)
+		return code
+	}
+
+	code := exitOK
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// files named explicitly are formatted whatever their extension
+			if d.IsDir() || p != path && filepath.Ext(p) != sourceExt {
+				return nil
+			}
+			if c := fmtFile(p, opts, stdout, stderr); c != exitOK && code == exitOK {
+				code = c
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey: %s\n", err)
+			code = exitIOError
+		}
+	}
+	return code
+}
+
+func fmtFile(path string, opts fmtOptions, stdout, stderr io.Writer) int {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitIOError
+	}
+	out, code := fmtSource(path, src, opts, stdout, stderr)
+	if out == nil || !opts.write || bytes.Equal(src, out) {
+		return code
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitIOError
+	}
+	if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitIOError
+	}
+	return code
+}
+
+// fmtSource formats src read from name & prints the result or its diff as selected by opts
+// returns the formatted source, nil if src doesn't parse
+func fmtSource(name string, src []byte, opts fmtOptions, stdout, stderr io.Writer) ([]byte, int) {
+	out, err := format.Source(src, lexer.WithFile(name))
+	if err != nil {
+		var ferr *format.Error
+		if errors.As(err, &ferr) {
+			for _, d := range ferr.Diagnostics {
+				fmt.Fprintf(stderr, "%s\n", d)
+			}
+			return nil, exitParseError
+		}
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return nil, exitIOError
+	}
+
+	switch {
+	case opts.diff:
+		if diff := unifiedDiff(name, src, out); diff != "" {
+			fmt.Fprint(stdout, diff)
+			return out, exitUnformatted
+		}
+	case !opts.write:
+		stdout.Write(out)
+	}
+	return out, exitOK
+}
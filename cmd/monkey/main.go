@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"monkey/lsp"
+	"monkey/repl"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// exit codes returned by the cli
+const (
+	exitOK           = 0
+	exitParseError   = 1
+	exitCompileError = 2
+	exitRuntimeError = 3
+	exitUnformatted  = 4 // fmt -d found files that are not formatted
+	exitUsage        = 64
+	exitDataError    = 65 // bytecode file is corrupt or incompatible
+	exitIOError      = 74
+)
+
+const (
+	engineVM   = "vm"
+	engineEval = "eval"
+)
+
+const usage = `Usage:
+	monkey run [--engine=vm|eval] <file>   run a monkey source file
+	monkey repl [--engine=vm|eval]         start an interactive session
+	monkey build <file> [-o out.mkc]       compile a source file to bytecode
+	monkey exec <file.mkc>                 run precompiled bytecode
+	monkey fmt [-w] [-d] [files...]        format source files & directories, stdin without files
+	                                       -w overwrites the files, -d prints a diff & exits with 4 if any
+	monkey lsp                             start the language server on stdin & stdout
+
+Without a command monkey starts the repl.
+`
+
+func main() {
+	os.Exit(runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// runCLI dispatches the subcommand in args and returns the process exit code
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return startRepl(engineVM, stdin, stdout, stderr)
+	}
+
+	switch cmd, rest := args[0], args[1:]; cmd {
+	case "run":
+		fs, engine := newFlagSet("run", stderr)
+		files, err := parseFlags(fs, rest)
+		if err != nil || len(files) != 1 {
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		return runFile(files[0], *engine, stderr)
+	case "repl":
+		fs, engine := newFlagSet("repl", stderr)
+		if _, err := parseFlags(fs, rest); err != nil {
+			return exitUsage
+		}
+		return startRepl(*engine, stdin, stdout, stderr)
+	case "build":
+		fs := flag.NewFlagSet("build", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		out := fs.String("o", "", "output file, defaults to the input with a .mkc extension")
+		files, err := parseFlags(fs, rest)
+		if err != nil || len(files) != 1 {
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		if *out == "" {
+			*out = strings.TrimSuffix(files[0], filepath.Ext(files[0])) + ".mkc"
+		}
+		return buildFile(files[0], *out, stderr)
+	case "exec":
+		fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		files, err := parseFlags(fs, rest)
+		if err != nil || len(files) != 1 {
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		return execFile(files[0], stderr)
+	case "fmt":
+		fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		var opts fmtOptions
+		fs.BoolVar(&opts.write, "w", false, "write the result to the files instead of stdout")
+		fs.BoolVar(&opts.diff, "d", false, "print a diff instead of the formatted source")
+		files, err := parseFlags(fs, rest)
+		if err != nil {
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		return fmtFiles(files, opts, stdin, stdout, stderr)
+	case "lsp":
+		if len(rest) != 0 {
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		if err := lsp.NewServer(stdin, stdout).Run(); err != nil {
+			fmt.Fprintf(stderr, "monkey lsp: %s\n", err)
+			return exitIOError
+		}
+		return exitOK
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", cmd, usage)
+		return exitUsage
+	}
+}
+
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	engine := fs.String("engine", engineVM, "execution engine, vm or eval")
+	return fs, engine
+}
+
+// parseFlags parses args allowing flags to appear after positional arguments
+// returns the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func startRepl(engine string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if engine != engineVM && engine != engineEval {
+		fmt.Fprintf(stderr, "unknown engine %q, want vm or eval\n", engine)
+		return exitUsage
+	}
+
+	username := "there"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	fmt.Fprintf(stdout, "Hello %s! This is the Monkey programming language!\n", username)
+	fmt.Fprintf(stdout, "Fee free to type in commands\n")
+	if engine == engineEval {
+		repl.StartEval(stdin, stdout)
+	} else {
+		repl.Start(stdin, stdout)
+	}
+	return exitOK
+}
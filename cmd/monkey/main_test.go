@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		src      string
+		engine   string
+		expected int
+	}{
+		{"let a = 1; a + 1;", engineVM, exitOK},
+		{"let a = 1; a + 1;", engineEval, exitOK},
+		{"let a = 1 +;", engineVM, exitParseError},
+		{"let a = 1 +;", engineEval, exitParseError},
+		{"let a = b;", engineVM, exitCompileError},
+		{"[1, zzz];", engineVM, exitCompileError},
+		{`1 + "a";`, engineVM, exitRuntimeError},
+		{`1 + "a";`, engineEval, exitRuntimeError},
+		// a builtin called back with bad arguments fails the run on both engines
+		{"map([1, 2], len);", engineVM, exitRuntimeError},
+		{"map([1, 2], len);", engineEval, exitRuntimeError},
+		{`let x = len(1); print("after");`, engineVM, exitRuntimeError},
+		{`let x = len(1); print("after");`, engineEval, exitRuntimeError},
+	}
+
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, "script.mk")
+		if err := os.WriteFile(path, []byte(tt.src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+		code := runCLI([]string{"run", "--engine=" + tt.engine, path}, nil, &stdout, &stderr)
+		if code != tt.expected {
+			t.Errorf("tests[%d] - wrong exit code for %q on %s. want=%d, got=%d (%s)",
+				i, tt.src, tt.engine, tt.expected, code, stderr.String())
+		}
+	}
+}
+
+func TestEnginesAgree(t *testing.T) {
+	tests := []string{
+		`let x = len(1); print("after");`,
+		`let h = keys([1]); h;`,
+		`let f = fn() { upper(1) }; f(); 1;`,
+		`len("abc");`,
+	}
+
+	dir := t.TempDir()
+	for _, src := range tests {
+		path := filepath.Join(dir, "script.mk")
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		codes := map[string]int{}
+		for _, engine := range []string{engineVM, engineEval} {
+			var stdout, stderr bytes.Buffer
+			codes[engine] = runCLI([]string{"run", "--engine=" + engine, path}, nil, &stdout, &stderr)
+		}
+		if codes[engineVM] != codes[engineEval] {
+			t.Errorf("engines disagree on %q. vm=%d, eval=%d", src, codes[engineVM], codes[engineEval])
+		}
+	}
+}
+
+func TestRuntimeErrorTraceback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fail.mk")
+	src := "let f = fn(x) { x + \"a\" };\nf(1);"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runCLI([]string{"run", path}, nil, &stdout, &stderr); code != exitRuntimeError {
+		t.Fatalf("wrong exit code. want=%d, got=%d", exitRuntimeError, code)
+	}
+	expected := "Traceback (most recent call last):\n" +
+		fmt.Sprintf("  File %q, line 2, column 1, in <main>\n", path) +
+		fmt.Sprintf("  File %q, line 1, column 17, in f\n", path) +
+		"RuntimeError: unsupported types for binary operation: INTEGER, STRING (in OpAdd)\n"
+	if stderr.String() != expected {
+		t.Errorf("wrong traceback. want=\n%s\ngot=\n%s", expected, stderr.String())
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	tests := [][]string{
+		{"bogus"},
+		{"run"},
+		{"run", "--engine=jit", "x.mk"},
+		{"repl", "--engine=jit"},
+	}
+
+	for _, args := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := runCLI(args, nil, &stdout, &stderr); code != exitUsage {
+			t.Errorf("wrong exit code for %v. want=%d, got=%d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestBuildAndExec(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "script.mk")
+	if err := os.WriteFile(src, []byte(`let f = fn(x) { x + 1 }; f(1);`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runCLI([]string{"build", src}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("build failed with %d: %s", code, stderr.String())
+	}
+	out := filepath.Join(dir, "script.mkc")
+	if code := runCLI([]string{"exec", out}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("exec failed with %d: %s", code, stderr.String())
+	}
+
+	custom := filepath.Join(dir, "custom.mkc")
+	if code := runCLI([]string{"build", src, "-o", custom}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("build -o failed with %d: %s", code, stderr.String())
+	}
+	if _, err := os.Stat(custom); err != nil {
+		t.Fatalf("build -o did not write %s: %s", custom, err)
+	}
+
+	if err := os.WriteFile(out, []byte("not bytecode"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runCLI([]string{"exec", out}, nil, &stdout, &stderr); code != exitDataError {
+		t.Errorf("wrong exit code for invalid bytecode. want=%d, got=%d", exitDataError, code)
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir := t.TempDir()
+	messy := filepath.Join(dir, "messy.mk")
+	tidy := filepath.Join(dir, "sub", "tidy.mk")
+	other := filepath.Join(dir, "notes.txt")
+	os.MkdirAll(filepath.Dir(tidy), 0o755)
+	files := map[string]string{
+		messy: "let x=1\nlet f = fn(a) { a*x }\n",
+		tidy:  "let y = 2;\n",
+		other: "let z 3",
+	}
+	for path, src := range files {
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	formatted := "let x = 1;\nlet f = fn(a) {\n\ta * x\n};\n"
+
+	var stdout, stderr bytes.Buffer
+	if code := runCLI([]string{"fmt", messy}, nil, &stdout, &stderr); code != exitOK || stdout.String() != formatted {
+		t.Errorf("wrong fmt output, code=%d, got=%q (%s)", code, stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	code := runCLI([]string{"fmt", "-d", dir}, nil, &stdout, &stderr)
+	expected := fmt.Sprintf("--- %s.orig\n+++ %s\n", messy, messy) +
+		"@@ -1,2 +1,4 @@\n" +
+		"-let x=1\n" +
+		"-let f = fn(a) { a*x }\n" +
+		"+let x = 1;\n" +
+		"+let f = fn(a) {\n" +
+		"+\ta * x\n" +
+		"+};\n"
+	if code != exitUnformatted || stdout.String() != expected {
+		t.Errorf("wrong fmt -d output, code=%d, want=\n%s\ngot=\n%s", code, expected, stdout.String())
+	}
+
+	stdout.Reset()
+	if code := runCLI([]string{"fmt", "-w", dir}, nil, &stdout, &stderr); code != exitOK || stdout.Len() != 0 {
+		t.Errorf("wrong fmt -w result, code=%d, output=%q", code, stdout.String())
+	}
+	for path, want := range map[string]string{messy: formatted, tidy: files[tidy], other: files[other]} {
+		if got, _ := os.ReadFile(path); string(got) != want {
+			t.Errorf("wrong content of %s after fmt -w, want=%q, got=%q", path, want, got)
+		}
+	}
+	if code := runCLI([]string{"fmt", "-d", dir}, nil, &stdout, &stderr); code != exitOK || stdout.Len() != 0 {
+		t.Errorf("expected no diff after fmt -w, code=%d, output=%q", code, stdout.String())
+	}
+
+	stderr.Reset()
+	if code := runCLI([]string{"fmt", other}, nil, &stdout, &stderr); code != exitParseError {
+		t.Errorf("wrong exit code for a file that doesn't parse, want=%d, got=%d", exitParseError, code)
+	}
+	if want := other + ":1:7: expected next token to be =, got INT instead\n"; stderr.String() != want {
+		t.Errorf("wrong parse errors, want=%q, got=%q", want, stderr.String())
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n")
+	b := []byte("1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\nsixteen\n")
+	expected := "--- f.orig\n+++ f\n" +
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+		"@@ -13,3 +13,4 @@\n 13\n 14\n 15\n+sixteen\n"
+	if diff := unifiedDiff("f", a, b); diff != expected {
+		t.Errorf("wrong diff, want=\n%s\ngot=\n%s", expected, diff)
+	}
+	if diff := unifiedDiff("f", a, a); diff != "" {
+		t.Errorf("expected no diff for equal input, got=\n%s", diff)
+	}
+}
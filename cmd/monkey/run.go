@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"os"
+)
+
+// runFile parses the file at path and executes it with the given engine
+func runFile(path string, engine string, stderr io.Writer) int {
+	if engine != engineVM && engine != engineEval {
+		fmt.Fprintf(stderr, "unknown engine %q, want vm or eval\n", engine)
+		return exitUsage
+	}
+	program, code := parseFile(path, stderr)
+	if code != exitOK {
+		return code
+	}
+
+	switch engine {
+	case engineVM:
+		bc, code := compileProgram(program, stderr)
+		if code != exitOK {
+			return code
+		}
+		return runBytecode(bc, stderr)
+	case engineEval:
+		env := object.NewEnv()
+		if res := evaluator.Eval(program, env); res != nil && res.Type() == object.ERROR_OBJ {
+			fmt.Fprintf(stderr, "runtime error: %s\n", res.(*object.Error).Message)
+			return exitRuntimeError
+		}
+	}
+	return exitOK
+}
+
+// buildFile compiles the source file at path and writes the serialized bytecode to out
+func buildFile(path string, out string, stderr io.Writer) int {
+	program, code := parseFile(path, stderr)
+	if code != exitOK {
+		return code
+	}
+	bc, code := compileProgram(program, stderr)
+	if code != exitOK {
+		return code
+	}
+
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		fmt.Fprintf(stderr, "compile error: %s\n", err)
+		return exitCompileError
+	}
+	if err := os.WriteFile(out, data, 0o644); err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitIOError
+	}
+	return exitOK
+}
+
+// execFile loads bytecode written by buildFile and runs it on the vm
+func execFile(path string, stderr io.Writer) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitIOError
+	}
+	bc := &compiler.Bytecode{}
+	if err := bc.UnmarshalBinary(data); err != nil {
+		fmt.Fprintf(stderr, "monkey: %s: %s\n", path, err)
+		return exitDataError
+	}
+	return runBytecode(bc, stderr)
+}
+
+func parseFile(path string, stderr io.Writer) (*ast.Program, int) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return nil, exitIOError
+	}
+
+	l := lexer.New(string(src), lexer.WithFile(path))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		for _, msg := range errors {
+			fmt.Fprintf(stderr, "%s\n", msg)
+		}
+		return nil, exitParseError
+	}
+	return program, exitOK
+}
+
+func compileProgram(program *ast.Program, stderr io.Writer) (*compiler.Bytecode, int) {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(stderr, "compile error: %s\n", err)
+		return nil, exitCompileError
+	}
+	return comp.Bytecode(), exitOK
+}
+
+func runBytecode(bc *compiler.Bytecode, stderr io.Writer) int {
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		var rerr *vm.RuntimeError
+		if errors.As(err, &rerr) {
+			fmt.Fprint(stderr, rerr.Traceback())
+		} else {
+			fmt.Fprintf(stderr, "runtime error: %s\n", err)
+		}
+		return exitRuntimeError
+	}
+	return exitOK
+}
@@ -4,10 +4,27 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/fnv"
+	"monkey/token"
 )
 
 type Instructions []byte
 
+// SourceMap maps the offset of an instruction to the source position it was compiled from
+type SourceMap map[int]token.Position
+
+// Lookup returns the source position of the instruction containing offset
+// offset may point into the operands of an instruction
+func (sm SourceMap) Lookup(offset int) token.Position {
+	// operands are at most 3 bytes wide, so the opcode is never far behind
+	for i := offset; i >= 0 && i > offset-4; i-- {
+		if pos, ok := sm[i]; ok {
+			return pos
+		}
+	}
+	return token.Position{}
+}
+
 func (ins Instructions) String() string {
 	var out bytes.Buffer
 
@@ -76,6 +93,18 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpIter
+	OpIterNext
+	OpSetFree
+	OpSetIndex
+	OpCaptureLocal
+	OpCaptureFree
+	OpInterpolate
+	OpMod
+	OpGreaterThanOrEqual
+	OpLoop
+	OpUnwind
+	OpEndLoop
 )
 
 // Definition defines the structure of an opcode.
@@ -123,7 +152,7 @@ var definitions = map[Opcode]*Definition{
 	// ophash does the same as oparray but doubles its values for the key-val pairings
 	OpHash: {"OpHash", []int{2}},
 	// pushes a null object onto the stack
-	OpIndex: {"OpNull", []int{}},
+	OpIndex: {"OpIndex", []int{}},
 	// returns the no of args a function call has
 	OpCall: {"OpCall", []int{1}},
 	// pops off the current frame and pushes null onto the stack
@@ -133,12 +162,41 @@ var definitions = map[Opcode]*Definition{
 	OpGetBuiltin:  {"OpGetBuiltin", []int{1}},
 	// Opclosure has 2 args, first arg is 2 bytes wide index that points to the location of the compiled fn in the constant stack
 	// the 2nd arg is the no of free variables used in this closure
-	// these free variables will hv to be pushed onto the stack before hand
+	// these free variables will hv to be pushed onto the stack before hand as upvalues
 	OpClosure: {"OpClosure", []int{2, 1}},
 	// gets the free variable from the current closure and pushes it onto the stack
 	OpGetFree: {"OpGetFree", []int{1}},
 	// pushes current closure onto the stack to allow for recursion
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	// pops an array, string or hash off the stack & pushes an iterator over it
+	OpIter: {"OpIter", []int{}},
+	// advances the iterator on top of the stack & pushes the next item
+	// once exhausted the iterator is popped and execution jumps to the operand
+	OpIterNext: {"OpIterNext", []int{2}},
+	// pops the value off the stack & stores it in the free variable of the current closure
+	OpSetFree: {"OpSetFree", []int{1}},
+	// pops the value, index & container off the stack, stores the value at the index & pushes it back
+	// the operand is the opcode of the binary operation combining the current element with the value
+	// for compound assignments, or 0 for plain assignment
+	OpSetIndex: {"OpSetIndex", []int{1}},
+	// push an upvalue for a local binding or a free variable of the current closure onto the stack
+	// used to capture variables before OpClosure
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+	// pops the no of parts given by the arg & pushes the string joining their Inspect()
+	// used for interpolated string literals
+	OpInterpolate: {"OpInterpolate", []int{2}},
+	// remainder of the integer division, or of the float division for float operands
+	OpMod: {"OpMod", []int{}},
+	// <= is compiled to >= with the operands swapped as for <
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	// records the stack pointer on entering a loop, for a break or continue inside an expression
+	// to drop the operands pending on the stack
+	OpLoop: {"OpLoop", []int{}},
+	// resets the stack pointer to the one recorded by the innermost OpLoop
+	OpUnwind: {"OpUnwind", []int{}},
+	// drops the stack pointer recorded by the innermost OpLoop once the loop is left
+	OpEndLoop: {"OpEndLoop", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -202,3 +260,17 @@ func ReadUint16(ins Instructions) uint16 {
 func ReadUint8(ins Instructions) uint8 {
 	return uint8(ins[0])
 }
+
+// Fingerprint returns a hash of the opcode definitions
+// bytecode is only valid when run against the definitions it was compiled with
+func Fingerprint() uint32 {
+	h := fnv.New32a()
+	for op := 0; op < 256; op++ {
+		def, ok := definitions[Opcode(op)]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(h, "%d:%s:%v;", op, def.Name, def.OperandWidths)
+	}
+	return h.Sum32()
+}
@@ -5,7 +5,7 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
-	"sort"
+	"monkey/token"
 )
 
 type Compiler struct {
@@ -13,17 +13,47 @@ type Compiler struct {
 	scopeIndex  int
 	scopes      []CompilationScope
 	symbolTable *SymbolTable
+	pos         token.Position // position of the node currently being compiled
 }
 
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	sourceMap           code.SourceMap
+	loops               []*loop // enclosing loops, innermost last
+}
+
+// loop tracks the jumps of a loop being compiled
+type loop struct {
+	start    int   // position continue jumps to
+	breaks   []int // positions of the break jumps, patched once the end of the loop is known
+	iterator bool  // for loops keep an iterator on the stack which break has to pop
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap
+}
+
+// Error is a compilation error pointing at the node that caused it
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// assignOperators maps compound assignment operators to the binary operation they apply
+var assignOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+	"%=": code.OpMod,
 }
 
 type EmittedInstruction struct {
@@ -31,32 +61,33 @@ type EmittedInstruction struct {
 	Position int
 }
 
+// New returns a compiler resolving builtins against the default registry
 func New() *Compiler {
+	return NewWithBuiltins(object.DefaultRegistry())
+}
+
+// NewWithBuiltins returns a compiler resolving builtins against r
+// the bytecode has to be run by a vm using the same registry, see vm.SetBuiltins
+func NewWithBuiltins(r *object.Registry) *Compiler {
+	return NewWithState(NewSymbolTableWithBuiltins(r), []object.Object{})
+}
+
+// NewWithState returns a compiler extending the symbol table & constants of previous compilations
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
+		sourceMap:           code.SourceMap{},
 	}
-	st := NewSymbolTable()
-	for i, builtin := range object.Builtins {
-		st.DefineBuiltin(i, builtin.Name)
-	}
-
 	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: st,
+		constants:   constants,
+		symbolTable: s,
 		scopeIndex:  0,
 		scopes:      []CompilationScope{mainScope},
 	}
 }
 
-func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	c := New()
-	c.constants = constants
-	c.symbolTable = s
-	return c
-}
-
 func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -65,11 +96,27 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
 	}
 }
 
+func (c *Compiler) errorf(node ast.Node, format string, a ...interface{}) error {
+	return &Error{Pos: node.Pos(), Message: fmt.Sprintf(format, a...)}
+}
+
 // Compile recursively walks thru the ast and adds byte code instructions to be executed by the vm
 func (c *Compiler) Compile(node ast.Node) error {
+	if node == nil {
+		return nil
+	}
+	// instructions emitted for this node are mapped to its position
+	// restore the parent's position once done as it may still emit instructions
+	if pos := node.Pos(); pos.IsValid() {
+		parentPos := c.pos
+		c.pos = pos
+		defer func() { c.pos = parentPos }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -88,16 +135,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.symbolTable.Outer != nil {
-			c.emit(code.OpSetLocal, symbol.Index)
-		} else {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		}
+		c.setSymbol(symbol)
 	case *ast.Identifier:
 		if symbol, ok := c.symbolTable.Resolve(node.Value); ok {
 			c.loadSymbol(symbol)
 		} else {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return c.errorf(node, "undefined variable %s", node.Value)
 		}
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
@@ -106,7 +149,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
+		if node.Operator == "<" || node.Operator == "<=" {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -115,9 +158,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
+			}
 			return nil
 		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
+		}
 
 		if err := c.Compile(node.Left); err != nil {
 			return err
@@ -135,8 +185,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
@@ -144,7 +198,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "<":
 			c.emit(code.OpGreaterThan)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return c.errorf(node, "unknown operator %s", node.Operator)
 		}
 	case *ast.PrefixExpression:
 		if err := c.Compile(node.Right); err != nil {
@@ -168,8 +222,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err := c.Compile(node.Consequence); err != nil {
 			return err
 		}
+		// the consequence has to leave a value on the stack, blocks not ending in an expression produce null
 		if c.lastInstructionIs(code.OpPop) {
 			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
 		}
 		// emit the jump instruction to the end of the if expression
 		jumpPos := c.emit(code.OpJump, 9999)
@@ -184,16 +241,92 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 		posAfterAlt := len(c.currentInstructions())
 		c.changeOperand(jumpPos, posAfterAlt)
+	case *ast.WhileStatement:
+		c.emit(code.OpLoop)
+		start := len(c.currentInstructions())
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exitPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.enterLoop(start, false)
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+		end := len(c.currentInstructions())
+		c.changeOperand(exitPos, end)
+		c.leaveLoop(end)
+		c.emit(code.OpEndLoop)
+	case *ast.ForStatement:
+		// the iterator stays on the stack for the duration of the loop
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+		c.emit(code.OpLoop)
+		start := c.emit(code.OpIterNext, 9999)
+		symbol := c.symbolTable.Define(node.Variable.Value)
+		c.setSymbol(symbol)
+
+		c.enterLoop(start, true)
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+		end := len(c.currentInstructions())
+		c.changeOperand(start, end)
+		c.leaveLoop(end)
+		c.emit(code.OpEndLoop)
+	case *ast.BreakStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return c.errorf(node, "break outside loop")
+		}
+		// break & continue may be inside an expression with operands pending on the stack
+		c.emit(code.OpUnwind)
+		if l.iterator {
+			c.emit(code.OpPop)
+		}
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+	case *ast.ContinueStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return c.errorf(node, "continue outside loop")
+		}
+		c.emit(code.OpUnwind)
+		c.emit(code.OpJump, l.start)
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
+	case *ast.InterpolatedString:
+		// empty text around the expressions adds nothing to the result
+		parts := 0
+		for i, s := range node.Strings {
+			if s != "" {
+				c.emit(code.OpConstant, c.addConstant(&object.String{Value: s}))
+				parts++
+			}
+			if i < len(node.Exprs) {
+				if err := c.Compile(node.Exprs[i]); err != nil {
+					return err
+				}
+				parts++
+			}
+		}
+		c.emit(code.OpInterpolate, parts)
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -203,18 +336,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			if err := c.Compile(el); err != nil {
-				return nil
+				return err
 			}
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for key := range node.Pairs {
-			keys = append(keys, key)
-		}
-
-		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
-		for _, key := range keys {
+		// pairs are compiled in source order, the order of the hash built by OpHash
+		for _, key := range node.Keys {
 			if err := c.Compile(key); err != nil {
 				return err
 			}
@@ -222,7 +350,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
-		c.emit(code.OpHash, len(keys)*2)
+		c.emit(code.OpHash, len(node.Keys)*2)
 	case *ast.IndexExpression:
 		if err := c.Compile(node.Left); err != nil {
 			return err
@@ -231,6 +359,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Member.Value}))
+		c.emit(code.OpIndex)
+	case *ast.AssignExpression:
+		if err := c.compileAssign(node); err != nil {
+			return err
+		}
 	case *ast.FuncLiteral:
 		c.enterScope()
 		if node.Name != "" {
@@ -253,17 +391,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// ensure that these values are taken from the nested scope before leaving
 		numLocals := c.symbolTable.numDef
 		freeSyms := c.symbolTable.FreeSymbols
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
 		instructions := c.leaveScope()
 
 		for _, sym := range freeSyms {
-			c.loadSymbol(sym)
+			c.captureSymbol(sym)
 		}
 
 		// a compiled func is seen as an obj by the compiler & is emited as an OpConstant
 		compiledFn := &object.CompiledFunction{
+			Name:         node.Name,
 			Instructions: instructions,
 			NumLocals:    numLocals,
 			NumArgs:      len(node.Parameters),
+			SourceMap:    sourceMap,
 		}
 		c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSyms))
 	case *ast.CallExpression:
@@ -297,10 +438,51 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
+	c.scopes[c.scopeIndex].sourceMap[pos] = c.pos
 
 	return pos
 }
 
+// compileLogical compiles && & || with jumps so the right operand is only evaluated
+// if the left one doesn't decide the result, the result is a boolean
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	notTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+	if node.Operator == "||" {
+		// a truthy left operand is the result
+		c.emit(code.OpTrue)
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(notTruthyPos, len(c.currentInstructions()))
+		if err := c.compileTruthiness(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	if err := c.compileTruthiness(node.Right); err != nil {
+		return err
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+	// a falsy left operand is the result
+	c.changeOperand(notTruthyPos, len(c.currentInstructions()))
+	c.emit(code.OpFalse)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileTruthiness compiles exp converted to a boolean, as !!exp
+func (c *Compiler) compileTruthiness(exp ast.Expression) error {
+	if err := c.Compile(exp); err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	return nil
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	c.scopes[c.scopeIndex].previousInstruction = c.scopes[c.scopeIndex].lastInstruction
 	c.scopes[c.scopeIndex].lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
@@ -349,6 +531,7 @@ func (c *Compiler) enterScope() {
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
+		sourceMap:           code.SourceMap{},
 	}
 	c.scopes = append(c.scopes, scope)
 	c.scopeIndex++
@@ -363,6 +546,99 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+// compileAssign leaves the assigned value on the stack as the result of the expression
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	op, compound := assignOperators[node.Operator]
+	if !compound && node.Operator != "=" {
+		return c.errorf(node, "unknown operator %s", node.Operator)
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return c.errorf(target, "undefined variable %s", target.Value)
+		}
+		if symbol.Scope == BuiltinScope || symbol.Scope == FunctionScope {
+			return c.errorf(target, "cannot assign to %s", target.Value)
+		}
+
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.setSymbol(symbol)
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex, int(op))
+	default:
+		return c.errorf(node, "cannot assign to %s", node.Target.String())
+	}
+	return nil
+}
+
+// setSymbol pops the value on top of the stack into the binding of a symbol
+func (c *Compiler) setSymbol(sym Symbol) {
+	switch sym.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, sym.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, sym.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, sym.Index)
+	}
+}
+
+// captureSymbol pushes the binding of a free symbol of a closure about to be created
+// locals & free variables are captured by reference so assignments are shared
+func (c *Compiler) captureSymbol(sym Symbol) {
+	switch sym.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, sym.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, sym.Index)
+	default:
+		c.loadSymbol(sym)
+	}
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) enterLoop(start int, iterator bool) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{start: start, iterator: iterator})
+}
+
+// leaveLoop patches the break jumps of the innermost loop to end
+func (c *Compiler) leaveLoop(end int) {
+	scope := &c.scopes[c.scopeIndex]
+	l := scope.loops[len(scope.loops)-1]
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, end)
+	}
+	scope.loops = scope.loops[:len(scope.loops)-1]
+}
+
 func (c *Compiler) loadSymbol(sym Symbol) {
 	// symbol could belong to outer or even the global scope
 	// cannot just check if the current symboltable is global or local
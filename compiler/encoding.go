@@ -0,0 +1,590 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+	"sort"
+)
+
+// Binary layout of serialized bytecode, all integers are big endian or varints
+//
+//	magic        "MKBC"
+//	version      uint16, the version of this format
+//	fingerprint  uint32, code.Fingerprint of the opcodes the bytecode was compiled with
+//	instructions uvarint length followed by the raw instructions
+//	source map   see encoder.sourceMap
+//	constants    uvarint count followed by tagged constants
+//	checksum     uint32, crc32 of everything before it
+const (
+	bytecodeMagic   = "MKBC"
+	bytecodeVersion = 2
+)
+
+// constant pool tags
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagCompiledFunction
+	tagFloat
+)
+
+var (
+	ErrInvalidBytecode      = errors.New("invalid bytecode")
+	ErrIncompatibleBytecode = errors.New("bytecode was compiled for a different opcode set")
+)
+
+// MarshalBinary encodes the bytecode into the versioned binary format
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	e := &encoder{files: map[string]uint64{}}
+	e.buf.WriteString(bytecodeMagic)
+	e.uint16(bytecodeVersion)
+	e.uint32(code.Fingerprint())
+	e.bytes(b.Instructions)
+	e.sourceMap(b.SourceMap)
+
+	e.uvarint(uint64(len(b.Constants)))
+	for _, c := range b.Constants {
+		if err := e.constant(c); err != nil {
+			return nil, err
+		}
+	}
+
+	e.uint32(crc32.ChecksumIEEE(e.buf.Bytes()))
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes bytecode produced by MarshalBinary
+// it refuses data compiled against a different set of opcode definitions
+// & instructions the compiler could not have produced, as far as verify can tell
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	header := len(bytecodeMagic) + 2 + 4
+	if len(data) < header+4 || string(data[:len(bytecodeMagic)]) != bytecodeMagic {
+		return fmt.Errorf("%w: missing header", ErrInvalidBytecode)
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidBytecode)
+	}
+
+	d := &decoder{data: body, pos: len(bytecodeMagic)}
+	if version := d.uint16(); version != bytecodeVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBytecode, version)
+	}
+	if fp := d.uint32(); fp != code.Fingerprint() {
+		return fmt.Errorf("%w: got %08x, want %08x", ErrIncompatibleBytecode, fp, code.Fingerprint())
+	}
+
+	instructions := code.Instructions(d.bytes())
+	sourceMap := d.sourceMap()
+	count := d.uvarint()
+	if count > uint64(len(d.data)) {
+		return fmt.Errorf("%w: constant count %d out of range", ErrInvalidBytecode, count)
+	}
+	constants := make([]object.Object, count)
+	for i := range constants {
+		constants[i] = d.constant()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if d.pos != len(d.data) {
+		return fmt.Errorf("%w: trailing data", ErrInvalidBytecode)
+	}
+	if err := verify(instructions, constants); err != nil {
+		return err
+	}
+
+	b.Instructions = instructions
+	b.SourceMap = sourceMap
+	b.Constants = constants
+	return nil
+}
+
+// verify checks the decoded instructions of the main program & of the functions in the constants:
+// every opcode is defined with all its operands, jumps land on an instruction,
+// constants, locals & free variables exist, functions have as many locals as parameters
+// and every path keeps the stack & the loop marks balanced, see verifyStack
+// global & builtin operands need no check, their width keeps them below vm.GlobalSize & object.MaxBuiltins
+// it does not check the types of values, e.g. calling an integer, the vm reports those as runtime errors
+func verify(main code.Instructions, constants []object.Object) error {
+	// free variables are only known from the closures made of a function, an unused function has none
+	numFree := map[int]int{}
+	streams := []code.Instructions{main}
+	for _, c := range constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			streams = append(streams, fn.Instructions)
+		}
+	}
+	for _, ins := range streams {
+		for ip := 0; ip < len(ins); {
+			def, err := code.Lookup(ins[ip])
+			if err != nil {
+				return fmt.Errorf("%w: %s at instruction %d", ErrInvalidBytecode, err, ip)
+			}
+			if code.Opcode(ins[ip]) == code.OpClosure && ip+3 < len(ins) {
+				fnIndex, free := int(code.ReadUint16(ins[ip+1:])), int(code.ReadUint8(ins[ip+3:]))
+				if n, ok := numFree[fnIndex]; !ok || free < n {
+					numFree[fnIndex] = free
+				}
+			}
+			ip += 1 + width(def)
+		}
+	}
+
+	if err := verifyInstructions(main, 0, 0, constants); err != nil {
+		return fmt.Errorf("main program: %w", err)
+	}
+	if err := verifyStack(main, false); err != nil {
+		return fmt.Errorf("main program: %w", err)
+	}
+	for i, c := range constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		// local operands are 1 byte wide
+		if fn.NumArgs < 0 || fn.NumArgs > fn.NumLocals || fn.NumLocals > 256 {
+			return fmt.Errorf("%w: function %d has %d locals for %d parameters", ErrInvalidBytecode, i, fn.NumLocals, fn.NumArgs)
+		}
+		if err := verifyInstructions(fn.Instructions, fn.NumLocals, numFree[i], constants); err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+		if err := verifyStack(fn.Instructions, true); err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func verifyInstructions(ins code.Instructions, numLocals, numFree int, constants []object.Object) error {
+	starts := map[int]bool{}
+	var jumps []int
+	for ip := 0; ip < len(ins); {
+		starts[ip] = true
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return fmt.Errorf("%w: %s at instruction %d", ErrInvalidBytecode, err, ip)
+		}
+		if ip+1+width(def) > len(ins) {
+			return fmt.Errorf("%w: truncated %s at instruction %d", ErrInvalidBytecode, def.Name, ip)
+		}
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+
+		bound := -1
+		switch code.Opcode(ins[ip]) {
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext:
+			jumps = append(jumps, ip)
+		case code.OpConstant:
+			bound = len(constants)
+		case code.OpClosure:
+			if operands[0] < len(constants) {
+				if _, ok := constants[operands[0]].(*object.CompiledFunction); !ok {
+					return fmt.Errorf("%w: closure of a %s at instruction %d", ErrInvalidBytecode, constants[operands[0]].Type(), ip)
+				}
+			}
+			bound = len(constants)
+		case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+			bound = numLocals
+		case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+			bound = numFree
+		case code.OpHash:
+			if operands[0]%2 != 0 {
+				return fmt.Errorf("%w: hash of %d values at instruction %d", ErrInvalidBytecode, operands[0], ip)
+			}
+		}
+		if bound >= 0 && operands[0] >= bound {
+			return fmt.Errorf("%w: operand %d of %s out of range at instruction %d", ErrInvalidBytecode, operands[0], def.Name, ip)
+		}
+		ip += 1 + read
+	}
+
+	for _, ip := range jumps {
+		target := int(code.ReadUint16(ins[ip+1:]))
+		if !starts[target] && target != len(ins) {
+			return fmt.Errorf("%w: jump to %d at instruction %d", ErrInvalidBytecode, target, ip)
+		}
+	}
+	return nil
+}
+
+// stackState is the stack of a frame as seen by verifyStack
+type stackState struct {
+	values []bool // one entry per value, true for the iterators pushed by OpIter
+	loops  []int  // the depth of the stack at each OpLoop not yet ended
+}
+
+func (s stackState) equal(other stackState) bool {
+	if len(s.values) != len(other.values) || len(s.loops) != len(other.loops) {
+		return false
+	}
+	for i := range s.values {
+		if s.values[i] != other.values[i] {
+			return false
+		}
+	}
+	for i := range s.loops {
+		if s.loops[i] != other.loops[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyStack follows every path through instructions already checked by verifyInstructions
+// & refuses those popping more values than they pushed, unwinding or ending a loop outside of one,
+// OpIterNext without an iterator on top of the stack & functions running past their last instruction
+// paths meeting at an instruction must agree on the stack, as the code the compiler emits does
+func verifyStack(ins code.Instructions, fn bool) error {
+	states := map[int]stackState{0: {}}
+	work := []int{0}
+	flow := func(ip int, s stackState) error {
+		if seen, ok := states[ip]; ok {
+			if !seen.equal(s) {
+				return fmt.Errorf("%w: paths disagree on the stack at instruction %d", ErrInvalidBytecode, ip)
+			}
+			return nil
+		}
+		states[ip] = s
+		work = append(work, ip)
+		return nil
+	}
+
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		if ip == len(ins) {
+			if fn {
+				return fmt.Errorf("%w: function runs past its last instruction", ErrInvalidBytecode)
+			}
+			continue
+		}
+
+		op := code.Opcode(ins[ip])
+		def, _ := code.Lookup(ins[ip])
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+		next := ip + 1 + read
+
+		s := stackState{
+			values: append([]bool(nil), states[ip].values...),
+			loops:  append([]int(nil), states[ip].loops...),
+		}
+		pops, pushes := stackEffect(op, operands)
+		if pops > len(s.values) {
+			return fmt.Errorf("%w: %s with %d values on the stack at instruction %d", ErrInvalidBytecode, def.Name, len(s.values), ip)
+		}
+		s.values = s.values[:len(s.values)-pops]
+		for i := 0; i < pushes; i++ {
+			s.values = append(s.values, op == code.OpIter)
+		}
+
+		switch op {
+		case code.OpJump:
+			next = operands[0]
+		case code.OpJumpNotTruthy:
+			if err := flow(operands[0], s); err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			if len(s.values) == 0 || !s.values[len(s.values)-1] {
+				return fmt.Errorf("%w: OpIterNext without an iterator at instruction %d", ErrInvalidBytecode, ip)
+			}
+			// the iterator is popped once done, each item is pushed on top of it
+			done := stackState{values: s.values[:len(s.values)-1], loops: s.loops}
+			if err := flow(operands[0], done); err != nil {
+				return err
+			}
+			s.values = append(s.values, false)
+		case code.OpLoop:
+			s.loops = append(s.loops, len(s.values))
+		case code.OpUnwind, code.OpEndLoop:
+			if len(s.loops) == 0 {
+				return fmt.Errorf("%w: %s outside of a loop at instruction %d", ErrInvalidBytecode, def.Name, ip)
+			}
+			mark := s.loops[len(s.loops)-1]
+			if op == code.OpEndLoop {
+				s.loops = s.loops[:len(s.loops)-1]
+			} else if mark > len(s.values) {
+				return fmt.Errorf("%w: OpUnwind below its loop at instruction %d", ErrInvalidBytecode, ip)
+			} else {
+				s.values = s.values[:mark]
+			}
+		case code.OpReturn, code.OpReturnValue:
+			continue
+		}
+		if err := flow(next, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackEffect returns the number of values an instruction pops & pushes
+// the jumps of OpJumpNotTruthy & OpIterNext and the loop marks are left to verifyStack
+func stackEffect(op code.Opcode, operands []int) (pops, pushes int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal,
+		code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure, code.OpCaptureLocal, code.OpCaptureFree:
+		return 0, 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpIndex,
+		code.OpGreaterThan, code.OpGreaterThanOrEqual, code.OpEqual, code.OpNotEqual:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpIter:
+		return 1, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal, code.OpSetFree, code.OpReturnValue:
+		return 1, 0
+	case code.OpArray, code.OpHash, code.OpInterpolate:
+		return operands[0], 1
+	case code.OpClosure:
+		return operands[1], 1
+	case code.OpCall:
+		return operands[0] + 1, 1
+	case code.OpSetIndex:
+		return 3, 1
+	}
+	return 0, 0
+}
+
+// width returns the number of bytes taken by the operands of an instruction
+func width(def *code.Definition) int {
+	n := 0
+	for _, w := range def.OperandWidths {
+		n += w
+	}
+	return n
+}
+
+type encoder struct {
+	buf   bytes.Buffer
+	files map[string]uint64 // file names already written, mapped to their index
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf.Write(binary.BigEndian.AppendUint16(nil, v))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf.Write(binary.AppendVarint(nil, v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+// file writes the index of a file name, the name itself is only written on its first use
+func (e *encoder) file(name string) {
+	if i, ok := e.files[name]; ok {
+		e.uvarint(i)
+		return
+	}
+	i := uint64(len(e.files))
+	e.files[name] = i
+	e.uvarint(i)
+	e.bytes([]byte(name))
+}
+
+// sourceMap writes the number of entries followed by offset, file, line, column & byte offset of each
+// entries are sorted by offset so the output is deterministic
+func (e *encoder) sourceMap(sm code.SourceMap) {
+	offsets := make([]int, 0, len(sm))
+	for offset := range sm {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	e.uvarint(uint64(len(sm)))
+	for _, offset := range offsets {
+		pos := sm[offset]
+		e.uvarint(uint64(offset))
+		e.file(pos.File)
+		e.uvarint(uint64(pos.Line))
+		e.uvarint(uint64(pos.Column))
+		e.uvarint(uint64(pos.Offset))
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.varint(obj.Value)
+	case *object.Float:
+		e.buf.WriteByte(tagFloat)
+		e.buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(obj.Value)))
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.bytes([]byte(obj.Value))
+	case *object.CompiledFunction:
+		e.buf.WriteByte(tagCompiledFunction)
+		e.bytes([]byte(obj.Name))
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumArgs))
+		e.bytes(obj.Instructions)
+		e.sourceMap(obj.SourceMap)
+	default:
+		return fmt.Errorf("cannot serialize constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+// decoder reads the values written by encoder
+// the first error is kept and all reads after it return zero values
+type decoder struct {
+	data  []byte
+	pos   int
+	files []string
+	err   error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s at byte %d", ErrInvalidBytecode, fmt.Sprintf(format, a...), d.pos)
+	}
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.pos+n > len(d.data) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("malformed varint")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("malformed varint")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail("length %d out of range", n)
+		return nil
+	}
+	b := d.next(int(n))
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
+
+func (d *decoder) file() string {
+	i := d.uvarint()
+	switch {
+	case i < uint64(len(d.files)):
+		return d.files[i]
+	case i == uint64(len(d.files)):
+		name := string(d.bytes())
+		d.files = append(d.files, name)
+		return name
+	default:
+		d.fail("file index %d out of range", i)
+		return ""
+	}
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	n := d.uvarint()
+	sm := code.SourceMap{}
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		offset := int(d.uvarint())
+		sm[offset] = token.Position{
+			File:   d.file(),
+			Line:   int(d.uvarint()),
+			Column: int(d.uvarint()),
+			Offset: int(d.uvarint()),
+		}
+	}
+	return sm
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(d.uint64())}
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	case tagCompiledFunction:
+		fn := &object.CompiledFunction{
+			Name:      string(d.bytes()),
+			NumLocals: int(d.uvarint()),
+			NumArgs:   int(d.uvarint()),
+		}
+		fn.Instructions = d.bytes()
+		fn.SourceMap = d.sourceMap()
+		return fn
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
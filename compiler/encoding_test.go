@@ -0,0 +1,260 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"monkey/code"
+	"monkey/object"
+	"testing"
+)
+
+func compileForEncoding(t *testing.T, input string) *Bytecode {
+	t.Helper()
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	input := `
+	let greet = fn(name) { "hello " + name };
+	let add = fn(a) { fn(b) { a + b } };
+	greet("monkey");
+	add(-1)(65536);
+	add(0.5)(1e-3);
+	`
+	bc := compileForEncoding(t, input)
+	data, err := bc.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	decoded := &Bytecode{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %s", err)
+	}
+	if decoded.Instructions.String() != bc.Instructions.String() {
+		t.Errorf("instructions differ.\nwant=%q\ngot=%q", bc.Instructions, decoded.Instructions)
+	}
+	if len(decoded.SourceMap) != len(bc.SourceMap) {
+		t.Errorf("wrong source map length. want=%d, got=%d", len(bc.SourceMap), len(decoded.SourceMap))
+	}
+	for offset, pos := range bc.SourceMap {
+		if decoded.SourceMap[offset] != pos {
+			t.Errorf("wrong source position at %d. want=%s, got=%s", offset, pos, decoded.SourceMap[offset])
+		}
+	}
+	if len(decoded.Constants) != len(bc.Constants) {
+		t.Fatalf("wrong no of constants. want=%d, got=%d", len(bc.Constants), len(decoded.Constants))
+	}
+	for i, c := range bc.Constants {
+		if c.Type() != decoded.Constants[i].Type() {
+			t.Errorf("constant %d has wrong type. want=%s, got=%s", i, c.Type(), decoded.Constants[i].Type())
+		}
+		if fn, ok := c.(*object.CompiledFunction); ok && fn.Name != decoded.Constants[i].(*object.CompiledFunction).Name {
+			t.Errorf("constant %d has wrong name. want=%q, got=%q", i, fn.Name, decoded.Constants[i].(*object.CompiledFunction).Name)
+		}
+		if f, ok := c.(*object.Float); ok && f.Value != decoded.Constants[i].(*object.Float).Value {
+			t.Errorf("constant %d has wrong value. want=%s, got=%s", i, f.Inspect(), decoded.Constants[i].Inspect())
+		}
+	}
+
+	again, err := decoded.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("encoding is not deterministic")
+	}
+}
+
+func TestBytecodeDecodingErrors(t *testing.T) {
+	data, err := compileForEncoding(t, `let a = "x"; a;`).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+
+	// rewrite the fingerprint and fix up the checksum so only the opcode check fails
+	foreign := append([]byte{}, data...)
+	binary.BigEndian.PutUint32(foreign[6:], 0xdeadbeef)
+	binary.BigEndian.PutUint32(foreign[len(foreign)-4:], crc32.ChecksumIEEE(foreign[:len(foreign)-4]))
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)/2] ^= 0xff
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", []byte{}, ErrInvalidBytecode},
+		{"bad magic", append([]byte("NOPE"), data[4:]...), ErrInvalidBytecode},
+		{"truncated", data[:len(data)-6], ErrInvalidBytecode},
+		{"corrupted", corrupted, ErrInvalidBytecode},
+		{"foreign opcodes", foreign, ErrIncompatibleBytecode},
+	}
+
+	for _, tt := range tests {
+		err := (&Bytecode{}).UnmarshalBinary(tt.data)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestBytecodeVerification(t *testing.T) {
+	concat := func(ins ...[]byte) code.Instructions {
+		var out code.Instructions
+		for _, i := range ins {
+			out = append(out, i...)
+		}
+		return out
+	}
+	fn := func(numLocals, numArgs int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals, NumArgs: numArgs}
+	}
+
+	tests := []struct {
+		name         string
+		instructions code.Instructions
+		constants    []object.Object
+		expected     string
+	}{
+		{
+			name:         "valid",
+			instructions: concat(code.Make(code.OpClosure, 1, 0), code.Make(code.OpConstant, 0), code.Make(code.OpCall, 1)),
+			constants: []object.Object{
+				&object.Integer{Value: 1},
+				fn(1, 1, code.Make(code.OpGetLocal, 0), code.Make(code.OpClosure, 2, 1), code.Make(code.OpReturnValue)),
+				fn(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+			},
+		},
+		{
+			name:         "undefined opcode",
+			instructions: code.Instructions{255},
+			expected:     "invalid bytecode: opcode 255 undefined at instruction 0",
+		},
+		{
+			name:         "truncated operand",
+			instructions: code.Make(code.OpConstant, 0)[:2],
+			constants:    []object.Object{&object.Integer{Value: 1}},
+			expected:     "main program: invalid bytecode: truncated OpConstant at instruction 0",
+		},
+		{
+			name:         "constant out of range",
+			instructions: code.Make(code.OpConstant, 0x7fff),
+			expected:     "main program: invalid bytecode: operand 32767 of OpConstant out of range at instruction 0",
+		},
+		{
+			name:         "closure of an integer",
+			instructions: code.Make(code.OpClosure, 0, 0),
+			constants:    []object.Object{&object.Integer{Value: 1}},
+			expected:     "main program: invalid bytecode: closure of a INTEGER at instruction 0",
+		},
+		{
+			name:         "jump into an operand",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 2)),
+			expected:     "main program: invalid bytecode: jump to 2 at instruction 1",
+		},
+		{
+			name:         "local in the main program",
+			instructions: code.Make(code.OpGetLocal, 0),
+			expected:     "main program: invalid bytecode: operand 0 of OpGetLocal out of range at instruction 0",
+		},
+		{
+			name:         "more parameters than locals",
+			instructions: code.Make(code.OpClosure, 0, 0),
+			constants:    []object.Object{fn(1, 2, code.Make(code.OpReturn))},
+			expected:     "invalid bytecode: function 0 has 1 locals for 2 parameters",
+		},
+		{
+			name:         "free variable out of range",
+			instructions: code.Make(code.OpClosure, 0, 0),
+			constants:    []object.Object{fn(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			expected:     "function 0: invalid bytecode: operand 0 of OpGetFree out of range at instruction 0",
+		},
+		{
+			name:         "return in the main program",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpReturnValue), code.Make(code.OpReturn)),
+		},
+		{
+			name:         "pop of an empty stack",
+			instructions: code.Make(code.OpPop),
+			expected:     "main program: invalid bytecode: OpPop with 0 values on the stack at instruction 0",
+		},
+		{
+			name:         "add with a single operand",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpAdd)),
+			expected:     "main program: invalid bytecode: OpAdd with 1 values on the stack at instruction 1",
+		},
+		{
+			name:         "call without the function",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpCall, 1)),
+			expected:     "main program: invalid bytecode: OpCall with 1 values on the stack at instruction 1",
+		},
+		{
+			name:         "odd hash",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpHash, 1)),
+			expected:     "main program: invalid bytecode: hash of 1 values at instruction 1",
+		},
+		{
+			name:         "unwind outside of a loop",
+			instructions: code.Make(code.OpUnwind),
+			expected:     "main program: invalid bytecode: OpUnwind outside of a loop at instruction 0",
+		},
+		{
+			name:         "end of a loop never started",
+			instructions: code.Make(code.OpEndLoop),
+			expected:     "main program: invalid bytecode: OpEndLoop outside of a loop at instruction 0",
+		},
+		{
+			name:         "unwind below the loop",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpLoop), code.Make(code.OpPop), code.Make(code.OpUnwind)),
+			expected:     "main program: invalid bytecode: OpUnwind below its loop at instruction 3",
+		},
+		{
+			name:         "next of a boolean",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpIterNext, 4)),
+			expected:     "main program: invalid bytecode: OpIterNext without an iterator at instruction 1",
+		},
+		{
+			name:         "next of an iterator stored in a local",
+			instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpNull), code.Make(code.OpCall, 1)),
+			constants: []object.Object{fn(1, 1,
+				code.Make(code.OpGetLocal, 0), code.Make(code.OpIter), code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpGetLocal, 0), code.Make(code.OpIterNext, 11), code.Make(code.OpReturn))},
+			expected: "function 0: invalid bytecode: OpIterNext without an iterator at instruction 7",
+		},
+		{
+			name:         "paths disagreeing on the stack",
+			instructions: concat(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 5), code.Make(code.OpNull), code.Make(code.OpPop)),
+			expected:     "main program: invalid bytecode: paths disagree on the stack at instruction 5",
+		},
+		{
+			name:         "function running past its end",
+			instructions: code.Make(code.OpClosure, 0, 0),
+			constants:    []object.Object{fn(0, 0, code.Make(code.OpNull))},
+			expected:     "function 0: invalid bytecode: function runs past its last instruction",
+		},
+	}
+
+	for _, tt := range tests {
+		data, err := (&Bytecode{Instructions: tt.instructions, Constants: tt.constants}).MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: MarshalBinary failed: %s", tt.name, err)
+		}
+		err = (&Bytecode{}).UnmarshalBinary(data)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidBytecode) || err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package compiler
 
+import "monkey/object"
+
 type SymbolScope string
 
 const (
@@ -30,6 +32,15 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
+// NewSymbolTableWithBuiltins creates a global symbol table with the builtins of r defined
+func NewSymbolTableWithBuiltins(r *object.Registry) *SymbolTable {
+	st := NewSymbolTable()
+	for i, name := range r.Names() {
+		st.DefineBuiltin(i, name)
+	}
+	return st
+}
+
 // NewEnclosedSymbolTable takes an Outer symbol table & creates a new enclosed table
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	st := NewSymbolTable()
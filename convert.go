@@ -0,0 +1,290 @@
+package monkey
+
+import (
+	"fmt"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// ToObject converts a go value to a monkey object
+//
+//	nil                      NULL
+//	bool                     BOOLEAN
+//	ints & uints             INTEGER
+//	float32, float64         FLOAT
+//	string                   STRING
+//	slices & arrays          ARRAY
+//	maps                     HASH, keys have to convert to a hashable object
+//	funcs                    BUILTIN, see WrapFunc
+//
+// objects are returned unchanged
+func ToObject(v interface{}) (object.Object, error) {
+	if v == nil {
+		return vm.Null, nil
+	}
+	if obj, ok := v.(object.Object); ok {
+		return obj, nil
+	}
+	return valueToObject(reflect.ValueOf(v))
+}
+
+func valueToObject(v reflect.Value) (object.Object, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return vm.True, nil
+		}
+		return vm.False, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > 1<<63-1 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			el, err := valueToObject(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		pairs := make([]object.HashPair, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := valueToObject(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := key.(object.Hashable); !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			val, err := valueToObject(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, object.HashPair{Key: key, Value: val})
+		}
+		// go maps are unordered, sorting the keys makes the hash deterministic
+		sort.Slice(pairs, func(i, j int) bool {
+			if c, ok := object.Compare(pairs[i].Key, pairs[j].Key); ok {
+				return c < 0
+			}
+			return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+		})
+		hash := object.NewHash(len(pairs))
+		for _, pair := range pairs {
+			hash.Set(pair.Key.(object.Hashable), pair.Value)
+		}
+		return hash, nil
+	case reflect.Func:
+		return WrapFunc(v.Interface())
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		if obj, ok := v.Interface().(object.Object); ok {
+			return obj, nil
+		}
+		return valueToObject(v.Elem())
+	}
+	return nil, fmt.Errorf("cannot convert %s to an object", v.Type())
+}
+
+// FromObject converts a monkey object to the matching go value
+// arrays become []interface{} & hashes map[interface{}]interface{}
+// objects without a go equivalent, e.g. functions, are returned unchanged
+func FromObject(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		out := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			out[i] = FromObject(el)
+		}
+		return out
+	case *object.Hash:
+		out := make(map[interface{}]interface{}, obj.Len())
+		for _, pair := range obj.Pairs() {
+			out[FromObject(pair.Key)] = FromObject(pair.Value)
+		}
+		return out
+	default:
+		return obj
+	}
+}
+
+// WrapFunc turns a go function into a builtin
+// parameters are converted from the arguments, object.Object parameters receive them unchanged
+// the function may return nothing, a value, an error or a value & an error
+// a non nil error is returned to monkey as an error object
+func WrapFunc(fn interface{}) (*object.Builtin, error) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("cannot wrap %s, not a function", t)
+	}
+
+	numOut := t.NumOut()
+	returnsErr := numOut > 0 && t.Out(numOut-1) == errorType
+	if numOut > 2 || numOut == 2 && !returnsErr {
+		return nil, fmt.Errorf("cannot wrap %s, want at most a result & an error", t)
+	}
+
+	call := func(args ...object.Object) object.Object {
+		in, err := convertArgs(t, args)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		out := v.Call(in)
+		if returnsErr {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return vm.Null
+		}
+		res, err := valueToObject(out[0])
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return res
+	}
+	return &object.Builtin{Fn: call}, nil
+}
+
+func convertArgs(t reflect.Type, args []object.Object) ([]reflect.Value, error) {
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			pt = t.In(numIn - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+		val, err := objectToValue(arg, pt)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		in[i] = val
+	}
+	return in, nil
+}
+
+// objectToValue converts obj to a go value of type t
+func objectToValue(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t == objectType {
+		return reflect.ValueOf(&obj).Elem(), nil
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			v.SetBool(b.Value)
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			if v.OverflowInt(i.Value) {
+				return v, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*object.Integer); ok {
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return v, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *object.Float:
+			v.SetFloat(n.Value)
+			return v, nil
+		case *object.Integer:
+			v.SetFloat(float64(n.Value))
+			return v, nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			v.SetString(s.Value)
+			return v, nil
+		}
+	case reflect.Slice:
+		if arr, ok := obj.(*object.Array); ok {
+			v.Set(reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements)))
+			for i, el := range arr.Elements {
+				ev, err := objectToValue(el, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.Index(i).Set(ev)
+			}
+			return v, nil
+		}
+	case reflect.Map:
+		if hash, ok := obj.(*object.Hash); ok {
+			v.Set(reflect.MakeMapWithSize(t, hash.Len()))
+			for _, pair := range hash.Pairs() {
+				kv, err := objectToValue(pair.Key, t.Key())
+				if err != nil {
+					return v, err
+				}
+				ev, err := objectToValue(pair.Value, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.SetMapIndex(kv, ev)
+			}
+			return v, nil
+		}
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			if goVal := FromObject(obj); goVal != nil {
+				v.Set(reflect.ValueOf(goVal))
+			}
+			return v, nil
+		}
+	}
+	return v, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
@@ -1,16 +1,18 @@
 package evaluator
 
 import (
+	"math"
 	"monkey/ast"
 	"monkey/object"
+	"strings"
 )
 
-func evalPrefixExpression(op string, right object.Object) object.Object {
+func (e *evaluator) evalPrefixExpression(op string, right object.Object) object.Object {
 	switch op {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusOperatorExpression(right)
+		return e.evalMinusOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", op, right.Type())
 	}
@@ -29,25 +31,34 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 
-func evalMinusOperatorExpression(right object.Object) object.Object {
+func (e *evaluator) evalMinusOperatorExpression(right object.Object) object.Object {
+	if f, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -f.Value}
+	}
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", right.Type())
 	}
 
 	intObj := right.(*object.Integer)
+	if intObj.Value == math.MinInt64 && e.checked {
+		return newError("integer overflow: -(%d)", intObj.Value)
+	}
 	negInt := &object.Integer{Value: -intObj.Value}
 
 	return negInt
 }
 
-func evalInfixExpression(op string, left object.Object, right object.Object) object.Object {
+func (e *evaluator) evalInfixExpression(op string, left object.Object, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(op, left, right)
+		return e.evalIntegerInfixExpression(op, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		// mixed integer & float operands are promoted to float
+		return evalFloatInfixExpression(op, object.ToFloat(left), object.ToFloat(right))
 	case op == "==":
-		return nativeBoolToObject(left == right)
+		return nativeBoolToObject(object.Equal(left, right))
 	case op == "!=":
-		return nativeBoolToObject(left != right)
+		return nativeBoolToObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), op, right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
@@ -57,19 +68,29 @@ func evalInfixExpression(op string, left object.Object, right object.Object) obj
 	}
 }
 
-func evalIntegerInfixExpression(op string, left object.Object, right object.Object) object.Object {
+func (e *evaluator) evalIntegerInfixExpression(op string, left object.Object, right object.Object) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
+	var res int64
+	ok := true
 	switch op {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		res, ok = object.CheckedAdd(leftVal, rightVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		res, ok = object.CheckedSub(leftVal, rightVal)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		res, ok = object.CheckedDiv(leftVal, rightVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		res, ok = object.CheckedMul(leftVal, rightVal)
+	case "%":
+		if rightVal == 0 {
+			return newError("modulo by zero")
+		}
+		res = leftVal % rightVal
 	case ">":
 		return nativeBoolToObject(leftVal > rightVal)
 	case ">=":
@@ -85,38 +106,176 @@ func evalIntegerInfixExpression(op string, left object.Object, right object.Obje
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
+	if !ok && e.checked {
+		return newError("integer overflow: %d %s %d", leftVal, op, rightVal)
+	}
+	return &object.Integer{Value: res}
+}
+
+func evalFloatInfixExpression(op string, leftVal float64, rightVal float64) object.Object {
+	switch op {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case ">":
+		return nativeBoolToObject(leftVal > rightVal)
+	case ">=":
+		return nativeBoolToObject(leftVal >= rightVal)
+	case "<":
+		return nativeBoolToObject(leftVal < rightVal)
+	case "<=":
+		return nativeBoolToObject(leftVal <= rightVal)
+	case "==":
+		return nativeBoolToObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", object.FLOAT_OBJ, op, object.FLOAT_OBJ)
+	}
 }
 
 func evalStringInfixExpression(op string, left object.Object, right object.Object) object.Object {
-	if op != "+" {
+	if op == "+" {
+		leftVal := left.(*object.String).Value
+		rightVal := right.(*object.String).Value
+		return &object.String{Value: leftVal + rightVal}
+	}
+
+	c, _ := object.Compare(left, right)
+	switch op {
+	case ">":
+		return nativeBoolToObject(c > 0)
+	case ">=":
+		return nativeBoolToObject(c >= 0)
+	case "<":
+		return nativeBoolToObject(c < 0)
+	case "<=":
+		return nativeBoolToObject(c <= 0)
+	default:
 		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
 	}
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
 }
 
-func evalIdentifier(id *ast.Identifier, env *object.Environment) object.Object {
+func (e *evaluator) evalIdentifier(id *ast.Identifier, env *object.Environment) object.Object {
 	if obj, ok := env.Get(id.Value); ok {
 		return obj
 	}
-	if builtin, ok := builtins[id.Value]; ok {
+	if builtin, ok := e.builtins.Lookup(id.Value); ok {
 		return builtin
 	}
 
 	return newError("identifier not found: %s", id.Value)
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	cond := Eval(ie.Condition, env)
-	if isError(cond) {
+// evalAssignExpression updates an existing binding or element & returns the assigned value
+func (e *evaluator) evalAssignExpression(ae *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := ae.Target.(type) {
+	case *ast.Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			if _, ok := e.builtins.Lookup(target.Value); ok {
+				return newError("cannot assign to builtin %s", target.Value)
+			}
+			return newError("identifier not found: %s", target.Value)
+		}
+		val := e.eval(ae.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if ae.Operator != "=" {
+			val = e.evalInfixExpression(strings.TrimSuffix(ae.Operator, "="), current, val)
+			if isError(val) {
+				return val
+			}
+		}
+		env.Assign(target.Value, val)
+		return val
+	case *ast.IndexExpression:
+		left := e.eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := e.eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		val := e.eval(ae.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if ae.Operator != "=" {
+			current := evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+			val = e.evalInfixExpression(strings.TrimSuffix(ae.Operator, "="), current, val)
+			if isError(val) {
+				return val
+			}
+		}
+		return evalIndexAssignment(left, index, val)
+	default:
+		return newError("cannot assign to %s", ae.Target.String())
+	}
+}
+
+func evalIndexAssignment(left object.Object, index object.Object, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", idx.Value)
+		}
+		left.Elements[idx.Value] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("type %s is not hashable", index.Type())
+		}
+		left.Set(key, val)
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+	return val
+}
+
+// evalLogicalExpression evaluates && & || to a boolean, the right operand only if the left
+// one doesn't decide the result
+func (e *evaluator) evalLogicalExpression(ie *ast.InfixExpression, env *object.Environment) object.Object {
+	left := e.eval(ie.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+	if isTruthy(left) == (ie.Operator == "||") {
+		return nativeBoolToObject(isTruthy(left))
+	}
+	right := e.eval(ie.Right, env)
+	if isAbrupt(right) {
+		return right
+	}
+	return nativeBoolToObject(isTruthy(right))
+}
+
+func (e *evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	cond := e.eval(ie.Condition, env)
+	if isAbrupt(cond) {
 		return cond
 	}
 	// if cond is truthy eval consequence
 	if isTruthy(cond) {
-		return Eval(ie.Consequence, env)
+		return e.eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
@@ -133,11 +292,11 @@ func isTruthy(condition object.Object) bool {
 	}
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var objs []object.Object
 	for _, exp := range exps {
-		obj := Eval(exp, env)
-		if isError(obj) {
+		obj := e.eval(exp, env)
+		if isAbrupt(obj) {
 			return []object.Object{obj}
 		}
 		objs = append(objs, obj)
@@ -145,12 +304,40 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return objs
 }
 
+func (e *evaluator) evalInterpolatedString(is *ast.InterpolatedString, env *object.Environment) object.Object {
+	parts := make([]object.Object, 0, len(is.Strings)+len(is.Exprs))
+	for i, s := range is.Strings {
+		if s != "" {
+			parts = append(parts, &object.String{Value: s})
+		}
+		if i < len(is.Exprs) {
+			obj := e.eval(is.Exprs[i], env)
+			if isAbrupt(obj) {
+				return obj
+			}
+			parts = append(parts, obj)
+		}
+	}
+	return object.Interpolate(parts)
+}
+
 func evalIndexExpression(left object.Object, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.MODULE_OBJ:
+		member, err := left.(*object.Module).Member(index)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return member
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		if s := left.(*object.String).Index(index.(*object.Integer).Value); s != nil {
+			return s
+		}
+		return NULL
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -165,24 +352,24 @@ func evalArrayIndexExpression(left object.Object, index object.Object) object.Ob
 	return arr.Elements[idx]
 }
 
-func evalHashExpression(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for k, v := range node.Pairs {
-		key := Eval(k, env)
-		if isError(key) {
+func (e *evaluator) evalHashExpression(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := object.NewHash(len(node.Keys))
+	for _, k := range node.Keys {
+		key := e.eval(k, env)
+		if isAbrupt(key) {
 			return key
 		}
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
 			return newError("type %s is not hashable", key.Type())
 		}
-		val := Eval(v, env)
-		if isError(val) {
+		val := e.eval(node.Pairs[k], env)
+		if isAbrupt(val) {
 			return val
 		}
-		pairs[hashKey.Hash()] = object.HashPair{Key: key, Value: val}
+		hash.Set(hashKey, val)
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
 
 }
 
@@ -192,8 +379,8 @@ func evalHashIndexExpression(left object.Object, index object.Object) object.Obj
 	if !ok {
 		return newError("type %s is not hashable", index.Type())
 	}
-	if pair, ok := hash.Pairs[idx.Hash()]; ok {
-		return pair.Value
+	if val, ok := hash.Get(idx); ok {
+		return val
 	} else {
 		return NULL
 	}
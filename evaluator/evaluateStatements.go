@@ -5,10 +5,10 @@ import (
 	"monkey/object"
 )
 
-func evalProgram(ss []ast.Statement, env *object.Environment) object.Object {
+func (e *evaluator) evalProgram(ss []ast.Statement, env *object.Environment) object.Object {
 	var evalObj object.Object
 	for _, stmt := range ss {
-		evalObj = Eval(stmt, env)
+		evalObj = e.eval(stmt, env)
 
 		switch obj := evalObj.(type) {
 		case *object.ReturnValue:
@@ -16,18 +16,78 @@ func evalProgram(ss []ast.Statement, env *object.Environment) object.Object {
 		case *object.Error:
 			return obj
 		}
+		if err := checkLoopControl(evalObj); err != nil {
+			return err
+		}
 	}
 	return evalObj
 }
 
-func evalBlockStatements(ss []ast.Statement, env *object.Environment) object.Object {
+func (e *evaluator) evalBlockStatements(ss []ast.Statement, env *object.Environment) object.Object {
 	var obj object.Object
 	for _, stmt := range ss {
-		obj = Eval(stmt, env)
-		rt := obj.Type()
-		if obj != nil && rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+		obj = e.eval(stmt, env)
+		if obj == nil {
+			continue
+		}
+		// returns, errors & loop control stop the block and are passed up to be handled
+		switch obj.Type() {
+		case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 			return obj
 		}
 	}
+	// blocks not ending in an expression evaluate to null
+	if obj == nil {
+		return NULL
+	}
 	return obj
 }
+
+func (e *evaluator) evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		cond := e.eval(ws.Condition, env)
+		if isAbrupt(cond) {
+			return cond
+		}
+		if !isTruthy(cond) {
+			return NULL
+		}
+		if res, exit := e.evalLoopBody(ws.Body, env); exit {
+			return res
+		}
+	}
+}
+
+func (e *evaluator) evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := e.eval(fs.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	it, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("type %s is not iterable", iterable.Type())
+	}
+	for item, ok := it.Next(); ok; item, ok = it.Next() {
+		env.Set(fs.Variable.Value, item)
+		if res, exit := e.evalLoopBody(fs.Body, env); exit {
+			return res
+		}
+	}
+	return NULL
+}
+
+// evalLoopBody evaluates one iteration of a loop
+// returns the object the loop evaluates to & true if the loop has to stop
+func (e *evaluator) evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	switch res := e.eval(body, env); res {
+	case BREAK:
+		return NULL, true
+	case CONTINUE, nil:
+		return nil, false
+	default:
+		if t := res.Type(); t == object.RETURN_VALUE_OBJ || t == object.ERROR_OBJ {
+			return res, true
+		}
+		return nil, false
+	}
+}
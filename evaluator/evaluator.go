@@ -1,96 +1,226 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monkey/ast"
 	"monkey/object"
+	"time"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
+)
+
+var (
+	ErrCanceled       = object.ErrCanceled
+	ErrBudgetExceeded = object.ErrBudgetExceeded
 )
 
+// checkInterval is the number of nodes evaluated between checks of the context
+const checkInterval = 1024
+
+// Options limits a single evaluation, zero values mean no limit
+type Options struct {
+	Context  context.Context
+	MaxSteps int64            // maximum number of nodes evaluated
+	Timeout  time.Duration    // wall clock limit of the evaluation
+	Builtins *object.Registry // builtins visible to the program, a new default registry if nil
+
+	// CheckedArithmetic makes integer arithmetic overflowing int64 an error instead of wrapping around
+	CheckedArithmetic bool
+}
+
+// evaluator holds the state of a single evaluation
+type evaluator struct {
+	ctx       context.Context
+	maxSteps  int64
+	steps     int64
+	nextCheck int64 // step at which the limits are checked next
+	err       error // set once a limit stopped the evaluation
+	builtins  *object.Registry
+	checked   bool
+}
+
+// Eval evaluates the node without any limits, with a default registry of its own
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	e := &evaluator{ctx: context.Background(), nextCheck: math.MaxInt64, builtins: object.DefaultRegistry()}
+	return e.eval(node, env)
+}
+
+// EvalWithOptions evaluates the node within the limits of opts
+// if a limit stops the evaluation the returned error wraps ErrCanceled or ErrBudgetExceeded
+// & is prefixed with the position of the node evaluation stopped at
+func EvalWithOptions(node ast.Node, env *object.Environment, opts Options) (object.Object, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	e := &evaluator{ctx: ctx, maxSteps: opts.MaxSteps, builtins: opts.Builtins, checked: opts.CheckedArithmetic}
+	if e.builtins == nil {
+		e.builtins = object.DefaultRegistry()
+	}
+	e.scheduleCheck()
+	res := e.eval(node, env)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return res, nil
+}
+
+// scheduleCheck sets the step at which the limits are checked next
+func (e *evaluator) scheduleCheck() {
+	next := int64(math.MaxInt64)
+	if e.ctx.Done() != nil {
+		next = e.steps + checkInterval
+	}
+	if e.maxSteps > 0 && e.maxSteps+1 < next {
+		next = e.maxSteps + 1
+	}
+	e.nextCheck = next
+}
+
+// checkLimits returns an error object once a limit is hit
+// the error object unwinds the evaluation like any other error
+func (e *evaluator) checkLimits(node ast.Node) object.Object {
+	if e.err == nil {
+		var err error
+		if e.maxSteps > 0 && e.steps > e.maxSteps {
+			err = fmt.Errorf("%w: evaluated %d nodes", ErrBudgetExceeded, e.maxSteps)
+		} else if ctxErr := e.ctx.Err(); ctxErr != nil {
+			err = fmt.Errorf("%w: %w", ErrCanceled, ctxErr)
+		} else {
+			e.scheduleCheck()
+			return nil
+		}
+		if node != nil && node.Pos().IsValid() {
+			err = fmt.Errorf("%s: %w", node.Pos(), err)
+		}
+		e.err = err
+	}
+	return newError("%s", e.err)
+}
+
+func (e *evaluator) eval(node ast.Node, env *object.Environment) object.Object {
+	if e.steps++; e.steps >= e.nextCheck {
+		if err := e.checkLimits(node); err != nil {
+			return err
+		}
+	}
+
 	switch node := node.(type) {
 	// STATEMENTS
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		return e.evalProgram(node.Statements, env)
 	case *ast.BlockStatement:
-		return evalBlockStatements(node.Statements, env)
+		return e.evalBlockStatements(node.Statements, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.eval(node.Expression, env)
 	case *ast.ReturnStatement:
-		obj := Eval(node.ReturnValue, env)
-		if isError(obj) {
+		obj := e.eval(node.ReturnValue, env)
+		if isAbrupt(obj) {
 			return obj
 		}
 		return &object.ReturnValue{Value: obj}
 	case *ast.LetStatement:
-		obj := Eval(node.Value, env)
-		if isError(obj) {
+		obj := e.eval(node.Value, env)
+		if isAbrupt(obj) {
 			return obj
 		}
 		env.Set(node.Name.Value, obj)
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return e.evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	// EXPRESSIONS
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return e.evalIdentifier(node, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return e.evalInterpolatedString(node, env)
 	case *ast.Boolean:
 		return nativeBoolToObject(node.Value)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := e.eval(node.Right, env)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return e.evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		right := Eval(node.Right, env)
-		if isError(left) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return e.evalLogicalExpression(node, env)
+		}
+		left := e.eval(node.Left, env)
+		if isAbrupt(left) {
 			return left
 		}
-		if isError(right) {
+		right := e.eval(node.Right, env)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return e.evalInfixExpression(node.Operator, left, right)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
 	case *ast.FuncLiteral:
 		return &object.Function{Env: env, Body: node.Body, Parameters: node.Parameters}
 	case *ast.CallExpression:
 		// find the function
-		function := Eval(node.Function, env)
-		if isError(function) {
+		function := e.eval(node.Function, env)
+		if isAbrupt(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := e.evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunc(function, args)
+		return e.applyFunc(function, args)
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		elements := e.evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.HashLiteral:
-		return evalHashExpression(node, env)
+		return e.evalHashExpression(node, env)
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		index := Eval(node.Index, env)
-		if isError(left) {
+		left := e.eval(node.Left, env)
+		if isAbrupt(left) {
 			return left
 		}
-		if isError(index) {
+		index := e.eval(node.Index, env)
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.MemberExpression:
+		left := e.eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		return evalIndexExpression(left, &object.String{Value: node.Member.Value})
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(node, env)
 	}
 
 	return nil
@@ -110,17 +240,46 @@ func isError(obj object.Object) bool {
 	return false
 }
 
+// isAbrupt reports whether obj ends the evaluation of the enclosing expressions:
+// an error, or a return, break or continue on its way to its function or loop
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
+	}
+	return false
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func applyFunc(obj object.Object, args []object.Object) object.Object {
+func (e *evaluator) applyFunc(obj object.Object, args []object.Object) object.Object {
 	switch fn := obj.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
 		extendedEnv := extendEnv(fn, args)
-		returnVal := Eval(fn.Body, extendedEnv)
+		returnVal := e.eval(fn.Body, extendedEnv)
+		if err := checkLoopControl(returnVal); err != nil {
+			return err
+		}
 		return unwrapReturn(returnVal)
 	case *object.Builtin:
+		if fn.CallFn != nil {
+			res, err := fn.CallFn(e, args...)
+			if err != nil {
+				if errObj, ok := err.(*object.Error); ok {
+					return errObj
+				}
+				return newError("%s", err)
+			}
+			if res == nil {
+				return NULL
+			}
+			return res
+		}
 		if res := fn.Fn(args...); res == nil {
 			return NULL
 		} else {
@@ -132,6 +291,15 @@ func applyFunc(obj object.Object, args []object.Object) object.Object {
 
 }
 
+// Call calls fn for a builtin, an error object it results in is returned as the error
+func (e *evaluator) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	res := e.applyFunc(fn, args)
+	if errObj, ok := res.(*object.Error); ok {
+		return nil, errObj
+	}
+	return res, nil
+}
+
 func extendEnv(fn *object.Function, args []object.Object) *object.Environment {
 	extendedEnv := object.NewEnclosedEnvironment(fn.Env)
 	for paramIndex, param := range fn.Parameters {
@@ -140,6 +308,17 @@ func extendEnv(fn *object.Function, args []object.Object) *object.Environment {
 	return extendedEnv
 }
 
+// checkLoopControl returns an error if a break or continue escaped its loop
+func checkLoopControl(obj object.Object) object.Object {
+	switch obj {
+	case BREAK:
+		return newError("break outside loop")
+	case CONTINUE:
+		return newError("continue outside loop")
+	}
+	return nil
+}
+
 func unwrapReturn(obj object.Object) object.Object {
 	if returnObj, ok := obj.(*object.ReturnValue); ok {
 		return returnObj.Value
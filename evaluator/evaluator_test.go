@@ -1,10 +1,14 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 func testEval(in string) object.Object {
@@ -71,6 +75,9 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"1 + 7 % 3 * 2", 3},
+		{"let x = 5; x %= 3; x", 2},
 	}
 
 	for _, tt := range tests {
@@ -103,6 +110,23 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 1", true},
+		{"2 >= 3", false},
+		{"true && 1", true},
+		{"true && false", false},
+		{"false || 0", true},
+		{"false || if (false) { 1 }", false},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"let n = 0; let f = fn() { n += 1; true }; false && f(); true || f(); n == 0", true},
+		{`"mon" + "key" == "monkey"`, true},
+		{`"a" < "b"`, true},
+		{`"abc" >= "abd"`, false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] != [2, 1]", true},
+		{`{"a": [1], 2: true} == {2: true, "a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
 	}
 
 	for _, tt := range tests {
@@ -271,6 +295,43 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3, true: 4}`, "{b: 1, a: 2, 3: 3, true: 4}"},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, "{b: 4, a: 2, c: 3}"},
+		{`let keys = ""; for (k in {"z": 1, "y": 2, "x": 3}) { keys += k }; keys`, "zyx"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s, want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "monkey"; "hello ${name}!"`, "hello monkey!"},
+		{`"${1 + 2}${[1, "a"]}${true}"`, "3[1, a]true"},
+		{`let f = fn(x) { "<${x}>" }; f(f("a"))`, "<<a>>"},
+	}
+	for _, tt := range tests {
+		str, ok := testEval(tt.input).(*object.String)
+		if !ok || str.Value != tt.expected {
+			t.Errorf("wrong result for %s, want=%q, got=%v", tt.input, tt.expected, testEval(tt.input))
+		}
+	}
+
+	if err, ok := testEval(`"a ${b}"`).(*object.Error); !ok || err.Message != "identifier not found: b" {
+		t.Errorf("expected an error for an unknown identifier, got %v", testEval(`"a ${b}"`))
+	}
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"hello" + " " + "world"`
 	eval := testEval(input)
@@ -314,6 +375,112 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected interface{}
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int64{2, 4, 6}},
+		{`map([[1], [1, 2]], len)`, []int64{1, 2}},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, []int64{2, 4}},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)`, 16},
+		{`reduce([1, 2, 3], fn(acc, x) { acc * x })`, 6},
+		{`sort([3, 1, 2])`, []int64{1, 2, 3}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int64{3, 2, 1}},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`if (all([], fn(x) { false })) { 1 } else { 2 }`, 1},
+		{`find([1, 2, 3, 4], fn(x) { x > 2 })`, 3},
+		{`find([1, 2], fn(x) { x > 2 })`, nil},
+		{`map([1, 2], fn(x) { x + true })`, "type mismatch: INTEGER + BOOLEAN"},
+		{`filter([1], fn(a, b) { a })`, "wrong number of arguments: want=2, got=1"},
+		{`sort([1, "a"])`, "cannot compare STRING with INTEGER"},
+		{`reduce([], fn(acc, x) { acc + x })`, "reduce of empty array with no initial value"},
+		{`map([1, 2], len)`, "argument to `len` not supported, got INTEGER"},
+		{`filter([1], fn(x) { len(x) })`, "argument to `len` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.in)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		case []int64:
+			arr, ok := evaluated.(*object.Array)
+			if !ok || len(arr.Elements) != len(expected) {
+				t.Errorf("wrong result for %q, want=%v, got=%s", tt.in, expected, evaluated.Inspect())
+				continue
+			}
+			for i, el := range expected {
+				testIntegerObject(t, arr.Elements[i], el)
+			}
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong error for %q, want=%q, got=%s", tt.in, expected, evaluated.Inspect())
+			}
+		}
+	}
+
+	// limits still apply to functions called back by a builtin
+	program := parser.New(lexer.New("map([1], fn(x) { while (true) {} })")).ParseProgram()
+	if _, err := EvalWithOptions(program, object.NewEnv(), Options{MaxSteps: 1000}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got %v", err)
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{`len({"a": 1, "b": 2})`, "2"},
+		{`keys({"b": 1, "a": 2})`, "[b, a]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`entries({"b": 1})`, "[[b, 1]]"},
+		{`has({"a": if (false) { 1 }}, "a")`, "true"},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [h, d]`, "[{a: 1, b: 2}, {b: 2}]"},
+		{`merge({"a": 1, "b": 2}, {"b": 3})`, "{a: 1, b: 3}"},
+		{`has({}, fn() {})`, "Error: unusable as hash key: FUNC"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.in).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{`len("héllo")`, "5"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[5]`, "null"},
+		{`split("a,b", ",")`, "[a, b]"},
+		{`join(["a", 1], ", ")`, "a, 1"},
+		{`upper(trim(" ok "))`, "OK"},
+		{`contains("monkey", "key")`, "true"},
+		{`indexOf("héllo", "llo")`, "2"},
+		{`substr("héllo", 1, 2)`, "él"},
+		{`chars("añ")`, "[a, ñ]"},
+		{`format("{}-{}", 1, "b")`, "1-b"},
+		{`lower([])`, "Error: argument 1 to `lower` must be STRING, got ARRAY"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.in).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.in, tt.expected, got)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -381,11 +548,11 @@ func TestHashLiterals(t *testing.T) {
 		TRUE.Hash():                             5,
 		FALSE.Hash():                            6,
 	}
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong no of pairs, got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong no of pairs, got=%d", result.Len())
 	}
-	for expKey, expVal := range expected {
-		if pair, ok := result.Pairs[expKey]; ok {
+	for _, pair := range result.Pairs() {
+		if expVal, ok := expected[pair.Key.(object.Hashable).Hash()]; ok {
 			testIntegerObject(t, pair.Value, expVal)
 		} else {
 			t.Errorf("no pair for given key in pairs")
@@ -416,3 +583,298 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected interface{}
+	}{
+		{"let r = 0; while (r < 3) { let r = r + 1; } r", 3},
+		{"let r = 0; while (true) { let r = 5; break; } r", 5},
+		{"let r = 0; for (x in [1, 2, 3]) { let r = r + x; } r", 6},
+		{"let r = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let r = r + x; } r", 3},
+		{"let r = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } let r = r + x; } r", 4},
+		{`let r = ""; for (c in "héllo") { let r = r + c; } r`, "héllo"},
+		{`let r = 0; for (k in {"a": 1}) { let r = k; } r`, "a"},
+		{"let f = fn(arr) { for (x in arr) { if (x > 1) { return x; } } return 0; }; f([1, 5, 3])", 5},
+		{"if (true) { let a = 1; }", nil},
+		{"for (x in 1) { x }", "type INTEGER is not iterable"},
+		{"break;", "break outside loop"},
+		{"fn() { continue; }()", "continue outside loop"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.in)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("wrong string for %q, expected=%q, got=%q", tt.in, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("wrong error for %q, expected=%q, got=%q", tt.in, expected, obj.Message)
+				}
+			default:
+				t.Errorf("unexpected object for %q: %T (%+v)", tt.in, evaluated, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+// break, continue & return inside an expression end the evaluation of its operands
+func TestLoopControlInExpressions(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected int64
+	}{
+		{`let i = 0; while (i < 5000) { i = i + 1; len(if (true) { continue; } else { "a" }) } i`, 5000},
+		{"let i = 0; while (true) { i = i + 1; [1, 2, if (i == 5000) { break; }] } i", 5000},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + [x, if (x == 2) { break; }][0] } s", 1},
+		{"let n = 0; while (true) { (if (true) { break; } else { 1 }) + (n = n + 1) } n", 0},
+		{
+			"let f = fn() { let s = 0; for (x in [1, 2, 3]) { for (y in [1, 2]) { s += x * (if (y == 2) { continue; } else { y }) } } s }; f()",
+			6,
+		},
+		{`let f = fn() { len(if (true) { return 7; } else { "a" }) }; f()`, 7},
+		// builtin calls inside a loop leave nothing behind on the stack
+		{`let i = 0; let n = 0; while (i < 5000) { i = i + 1; n = n + len("a") } n`, 5000},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.in), tt.expected)
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let i = 0; while (i < 5) { i += 1; } i", 5},
+		{"let arr = [1, 2, 3]; arr[2] *= 10; arr[2]", 30},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"]`, 5},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let f = fn() { let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n }; f()", 2},
+		{"y = 1", "identifier not found: y"},
+		{"len = 1", "cannot assign to builtin len"},
+		{"let a = [1]; a[3] = 1", "index out of range: 3"},
+		{"let a = 1; a[0] = 1", "index assignment not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.in)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q, got=%T (%+v)", tt.in, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error for %q, expected=%q, got=%q", tt.in, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestFloatExpressions(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected interface{}
+	}{
+		{"1.5", 1.5},
+		{"0.5 + 0.25", 0.75},
+		{"2 * 1.5", 3.0},
+		{"7 / 2.0", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 > 1", true},
+		{"2.0 == 2", true},
+		{"int(3.9)", 3},
+		{`int("x")`, `cannot convert "x" to INTEGER`},
+		{"float(3)", 3.0},
+		{`float(true)`, "argument to `float` not supported, got BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.in)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case float64:
+			f, ok := evaluated.(*object.Float)
+			if !ok {
+				t.Errorf("object is not Float for %q, got=%T (%+v)", tt.in, evaluated, evaluated)
+				continue
+			}
+			if f.Value != expected {
+				t.Errorf("wrong value for %q, expected=%g, got=%g", tt.in, expected, f.Value)
+			}
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q, got=%T (%+v)", tt.in, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error for %q, expected=%q, got=%q", tt.in, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestEvalWithOptions(t *testing.T) {
+	loop := "let i = 0;\nwhile (true) { i += 1; }"
+	recursion := "let f = fn(n) { f(n + 1) };\nf(0);"
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		opts     Options
+		expected error
+	}{
+		{"1 + 2", Options{MaxSteps: 10}, nil},
+		{"1 + 2", Options{MaxSteps: 3}, ErrBudgetExceeded},
+		{loop, Options{MaxSteps: 1000}, ErrBudgetExceeded},
+		{recursion, Options{MaxSteps: 1000}, ErrBudgetExceeded},
+		{loop, Options{Context: canceled}, ErrCanceled},
+		{loop, Options{Timeout: 10 * time.Millisecond}, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		res, err := EvalWithOptions(program, object.NewEnv(), tt.opts)
+		if tt.expected == nil {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", tt.input, err)
+			}
+			testIntegerObject(t, res, 3)
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("wrong error for %q with %+v, want=%v, got=%v", tt.input, tt.opts, tt.expected, err)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "1:") && !strings.HasPrefix(err.Error(), "2:") {
+			t.Errorf("error for %q has no position: %v", tt.input, err)
+		}
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		checked  bool
+		expected string
+	}{
+		{"1 / 0", false, "Error: division by zero"},
+		{"let x = 1; x %= 0", false, "Error: modulo by zero"},
+		{"9223372036854775807 + 1", false, "-9223372036854775808"},
+		{"9223372036854775807 + 1", true, "Error: integer overflow: 9223372036854775807 + 1"},
+		{"let m = -9223372036854775807 - 1; -m", true, "Error: integer overflow: -(-9223372036854775808)"},
+		{"4611686018427387904 * 2", true, "Error: integer overflow: 4611686018427387904 * 2"},
+		{"pow(2, 62) - 1 + pow(2, 62)", true, "9223372036854775807"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		res, err := EvalWithOptions(program, object.NewEnv(), Options{CheckedArithmetic: tt.checked})
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", tt.input, err)
+		}
+		if res.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.input, tt.expected, res.Inspect())
+		}
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{`json.parse("{\"b\": [1, 2.5], \"a\": null}")`, "{b: [1, 2.5], a: null}"},
+		{`json.stringify({"b": [1, "x"], "a": true})`, `{"b":[1,"x"],"a":true}`},
+		{`json.parse("[1,]")`, "Error: json.parse: invalid character ',' looking for beginning of value at offset 2"},
+		{`json.stringify(fn() {})`, "Error: json.stringify: cannot encode a value of type FUNC"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.in).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{`json`, "module json"},
+		{`json["parse"]("[1]")`, "[1]"},
+		{`let j = json; j.stringify({"a": 1})`, `{"a":1}`},
+		{`let h = {"a": {"b": 2}}; h.a.b`, "2"},
+		{`{"a": 1}.b`, "null"},
+		{`json.nope`, "Error: module json has no member nope"},
+		{`json[1]`, "Error: module json has no member 1"},
+		{`json["parse"] = 1`, "Error: index assignment not supported: MODULE"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.in).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestCyclicInspect(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{`let a = [1]; a[0] = a; a`, "[[...]]"},
+		{`let h = {"k": 1}; h["self"] = h; h`, "{k: 1, self: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; [a, h]`, "[[{a: [...]}], {a: [{...}]}]"},
+		{`let a = [1]; a[0] = a; "${a}"`, "[[...]]"},
+		{`let b = [1]; [b, b]`, "[[1], [1]]"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.in).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestCustomBuiltins(t *testing.T) {
+	sandbox := object.NewRegistry()
+	sandbox.Register("len", object.GetBuiltinByName("len"))
+
+	program := parser.New(lexer.New(`len("abc")`)).ParseProgram()
+	res, err := EvalWithOptions(program, object.NewEnv(), Options{Builtins: sandbox})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testIntegerObject(t, res, 3)
+
+	program = parser.New(lexer.New(`print("hi")`)).ParseProgram()
+	res, _ = EvalWithOptions(program, object.NewEnv(), Options{Builtins: sandbox})
+	errObj, ok := res.(*object.Error)
+	if !ok || errObj.Message != "identifier not found: print" {
+		t.Errorf("expected print to be undefined, got=%v", res)
+	}
+}
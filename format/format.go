@@ -0,0 +1,443 @@
+// Package format prints monkey programs in their canonical form
+//
+// statements go on their own line, blocks are indented with a tab, binary operators are
+// surrounded by spaces & parentheses are kept only where precedence needs them
+// a single blank line between statements is kept, lists of arguments, elements or pairs
+// starting on a new line in the source are printed one element per line
+// comments stay on their own line or after the statement or element they follow
+package format
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"monkey/ast"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+)
+
+// Error holds the diagnostics of source that failed to parse
+type Error struct {
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.String()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Source formats the monkey source src
+// returns an *Error if src doesn't parse
+func Source(src []byte, opts ...lexer.Option) ([]byte, error) {
+	opts = append(opts, lexer.WithComments())
+	p := parser.New(lexer.New(string(src), opts...))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &Error{Diagnostics: p.Errors()}
+	}
+
+	var out bytes.Buffer
+	if err := Node(&out, program); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Node writes the canonical form of node to w
+// a program ends with a newline, other nodes are printed without one
+// the comments of a program are kept, see ast.Program.Comments
+func Node(w io.Writer, node ast.Node) error {
+	p := &printer{}
+	switch node := node.(type) {
+	case *ast.Program:
+		p.comments = node.Comments
+		p.statements(node.Statements, false, token.Position{Offset: math.MaxInt})
+		if p.out.Len() > 0 {
+			p.newline()
+		}
+	case ast.Statement:
+		p.statement(node, false)
+	case ast.Expression:
+		p.expr(node, parser.LOWEST)
+	}
+	_, err := w.Write(p.out.Bytes())
+	return err
+}
+
+type printer struct {
+	out    bytes.Buffer
+	indent int
+
+	comments []token.Comment
+	next     int // index of the first comment not printed yet
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.out.WriteByte('\n')
+	for i := 0; i < p.indent; i++ {
+		p.out.WriteByte('\t')
+	}
+}
+
+// lineBreak starts a new line for an item on line next after one ending on line prev
+// keeping a single blank line if the source had any between them
+func (p *printer) lineBreak(prev, next int) {
+	p.newline()
+	if next > prev+1 {
+		// blank lines are written without the indentation
+		p.out.Truncate(p.out.Len() - p.indent)
+		p.newline()
+	}
+}
+
+func (p *printer) hasCommentBefore(pos token.Position) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Pos.Offset < pos.Offset
+}
+
+// commentsBefore prints the comments before pos on lines of their own
+// prev is the line of the item printed last, 0 if the comments start the line
+// returns the line the last comment ends on, prev if there are none
+func (p *printer) commentsBefore(pos token.Position, prev int) int {
+	for p.hasCommentBefore(pos) {
+		c := p.comments[p.next]
+		if prev > 0 {
+			p.lineBreak(prev, c.Pos.Line)
+		}
+		p.write(c.Text)
+		prev = c.End.Line
+		p.next++
+	}
+	return prev
+}
+
+// trailingComments prints the comments within an item ending at end & the ones following it on its line
+// returns the line the item ends on including the comments
+func (p *printer) trailingComments(end token.Position) int {
+	line := end.Line
+	for p.next < len(p.comments) {
+		c := p.comments[p.next]
+		if c.Pos.Offset >= end.Offset && c.Pos.Line != end.Line {
+			break
+		}
+		p.write(" " + c.Text)
+		line = c.End.Line
+		p.next++
+	}
+	return line
+}
+
+// statements prints stmts one per line followed by the comments before end
+// inBlock drops the ; after a trailing expression statement, the value of the block
+func (p *printer) statements(stmts []ast.Statement, inBlock bool, end token.Position) {
+	prev := 0
+	for i, stmt := range stmts {
+		prev = p.commentsBefore(stmt.Pos(), prev)
+		if prev > 0 {
+			p.lineBreak(prev, stmt.Pos().Line)
+		}
+		p.statement(stmt, inBlock && i == len(stmts)-1)
+		if i+1 < len(stmts) && needsSemicolon(stmt, stmts[i+1]) {
+			p.write(";")
+		}
+		prev = p.trailingComments(stmt.End())
+	}
+	p.commentsBefore(end, prev)
+}
+
+func (p *printer) statement(stmt ast.Statement, last bool) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let " + stmt.Name.Value + " = ")
+		p.expr(stmt.Value, parser.LOWEST)
+		p.write(";")
+	case *ast.ReturnStatement:
+		p.write("return ")
+		p.expr(stmt.ReturnValue, parser.LOWEST)
+		p.write(";")
+	case *ast.ExpressionStatement:
+		p.expr(stmt.Expression, parser.LOWEST)
+		if !last && !endsWithBlock(stmt.Expression) {
+			p.write(";")
+		}
+	case *ast.BlockStatement:
+		p.block(stmt)
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expr(stmt.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body)
+	case *ast.ForStatement:
+		p.write("for (" + stmt.Variable.Value + " in ")
+		p.expr(stmt.Iterable, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body)
+	case *ast.BreakStatement:
+		p.write("break;")
+	case *ast.ContinueStatement:
+		p.write("continue;")
+	}
+}
+
+// endsWithBlock reports whether exp is printed ending in a }, such statements need no ;
+func endsWithBlock(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.IfExpression, *ast.FuncLiteral:
+		return true
+	}
+	return false
+}
+
+// needsSemicolon reports whether a statement ending in } needs a ; to not continue into
+// the call, index or subtraction next starts with
+func needsSemicolon(stmt, next ast.Statement) bool {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok || !endsWithBlock(es.Expression) {
+		return false
+	}
+	nextES, ok := next.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	switch firstChar(nextES.Expression, parser.LOWEST) {
+	case '(', '[', '-':
+		return true
+	}
+	return false
+}
+
+// firstChar returns the first char expr prints for exp, 0 for identifiers, literals & keywords
+func firstChar(exp ast.Expression, min int) byte {
+	if precedence(exp) < min {
+		return '('
+	}
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return firstChar(exp.Left, infixPrecedences[exp.Operator])
+	case *ast.AssignExpression:
+		return firstChar(exp.Target, parser.ASSIGN+1)
+	case *ast.CallExpression:
+		return firstChar(exp.Function, parser.CALL)
+	case *ast.IndexExpression:
+		return firstChar(exp.Left, parser.INDEX)
+	case *ast.MemberExpression:
+		return firstChar(exp.Left, parser.CALL)
+	case *ast.PrefixExpression:
+		return exp.Operator[0]
+	case *ast.ArrayLiteral:
+		return '['
+	case *ast.HashLiteral:
+		return '{'
+	}
+	return 0
+}
+
+func (p *printer) block(b *ast.BlockStatement) {
+	if b == nil || len(b.Statements) == 0 && !p.hasCommentBefore(b.Rbrace) {
+		p.write("{}")
+		return
+	}
+	p.write("{")
+	p.indent++
+	p.newline()
+	p.statements(b.Statements, true, b.Rbrace)
+	p.indent--
+	p.newline()
+	p.write("}")
+}
+
+// precedence returns the binding power of exp as the parser sees it
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.InfixExpression:
+		return infixPrecedences[exp.Operator]
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression, *ast.MemberExpression:
+		return parser.INDEX
+	}
+	// literals & identifiers never need parentheses
+	return parser.INDEX + 1
+}
+
+var infixPrecedences = map[string]int{
+	"==": parser.EQUALS,
+	"!=": parser.EQUALS,
+	"&&": parser.AND,
+	"||": parser.OR,
+	"<":  parser.LESSGREATER,
+	">":  parser.LESSGREATER,
+	"<=": parser.LESSGREATER,
+	">=": parser.LESSGREATER,
+	"+":  parser.SUM,
+	"-":  parser.SUM,
+	"*":  parser.PRODUCT,
+	"/":  parser.PRODUCT,
+	"%":  parser.PRODUCT,
+}
+
+// expr prints exp, in parentheses if it binds looser than min
+func (p *printer) expr(exp ast.Expression, min int) {
+	if precedence(exp) < min {
+		p.write("(")
+		p.expr(exp, parser.LOWEST)
+		p.write(")")
+		return
+	}
+
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		p.write(exp.Value)
+	case *ast.IntegerLiteral:
+		p.write(exp.Token.Literal)
+	case *ast.FloatLiteral:
+		p.write(exp.Token.Literal)
+	case *ast.StringLiteral:
+		if exp.Token.Type == token.RAW_STRING {
+			p.write("`" + exp.Value + "`")
+		} else {
+			p.write(lexer.Quote(exp.Value))
+		}
+	case *ast.InterpolatedString:
+		p.write(`"`)
+		for i, s := range exp.Strings {
+			q := lexer.Quote(s)
+			p.write(q[1 : len(q)-1])
+			if i < len(exp.Exprs) {
+				p.write("${")
+				p.expr(exp.Exprs[i], parser.LOWEST)
+				p.write("}")
+			}
+		}
+		p.write(`"`)
+	case *ast.Boolean:
+		p.write(exp.Token.Literal)
+	case *ast.PrefixExpression:
+		p.write(exp.Operator)
+		p.expr(exp.Right, parser.PREFIX)
+	case *ast.InfixExpression:
+		// operators are left associative, an operand of the same precedence on the right needs parentheses
+		prec := infixPrecedences[exp.Operator]
+		p.expr(exp.Left, prec)
+		p.write(" " + exp.Operator + " ")
+		p.expr(exp.Right, prec+1)
+	case *ast.AssignExpression:
+		// assignments are right associative
+		p.expr(exp.Target, parser.ASSIGN+1)
+		p.write(" " + exp.Operator + " ")
+		p.expr(exp.Value, parser.ASSIGN)
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expr(exp.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(exp.Consequence)
+		if exp.Alternative != nil {
+			p.write(" else ")
+			p.block(exp.Alternative)
+		}
+	case *ast.FuncLiteral:
+		p.write("fn(")
+		for i, param := range exp.Parameters {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(param.Value)
+		}
+		p.write(") ")
+		p.block(exp.Body)
+	case *ast.CallExpression:
+		p.expr(exp.Function, parser.CALL)
+		p.exprList("(", ")", exp.Arguments, exp.Token.Pos, exp.Rparen)
+	case *ast.IndexExpression:
+		p.expr(exp.Left, parser.INDEX)
+		p.write("[")
+		p.expr(exp.Index, parser.LOWEST)
+		p.write("]")
+	case *ast.MemberExpression:
+		// calls & indexes chain with members, json.parse(s).a
+		p.expr(exp.Left, parser.CALL)
+		p.write(".")
+		p.write(exp.Member.Value)
+	case *ast.ArrayLiteral:
+		p.exprList("[", "]", exp.Elements, exp.Token.Pos, exp.Rbracket)
+	case *ast.HashLiteral:
+		p.hash(exp)
+	}
+}
+
+// item is an element of a list, pos & end are its span in the source
+type item struct {
+	pos, end token.Position
+	print    func()
+}
+
+// list prints comma separated items between open & close
+// if the source breaks the line before the first item every item goes on its own line
+// along with the comments around it, start & end are the positions of open & close
+func (p *printer) list(open, close string, items []item, trailingComma bool, start, end token.Position) {
+	p.write(open)
+	if len(items) == 0 || items[0].pos.Line == start.Line {
+		for i, it := range items {
+			if i > 0 {
+				p.write(", ")
+			}
+			it.print()
+		}
+		p.write(close)
+		return
+	}
+
+	p.indent++
+	for i, it := range items {
+		p.newline()
+		if line := p.commentsBefore(it.pos, 0); line > 0 {
+			p.lineBreak(line, it.pos.Line)
+		}
+		it.print()
+		if i < len(items)-1 || trailingComma {
+			p.write(",")
+		}
+		p.trailingComments(it.end)
+	}
+	if p.hasCommentBefore(end) {
+		p.newline()
+		p.commentsBefore(end, 0)
+	}
+	p.indent--
+	p.newline()
+	p.write(close)
+}
+
+func (p *printer) exprList(open, close string, exps []ast.Expression, start, end token.Position) {
+	items := make([]item, len(exps))
+	for i, exp := range exps {
+		exp := exp
+		items[i] = item{pos: exp.Pos(), end: exp.End(), print: func() { p.expr(exp, parser.LOWEST) }}
+	}
+	p.list(open, close, items, false, start, end)
+}
+
+func (p *printer) hash(h *ast.HashLiteral) {
+	items := make([]item, len(h.Keys))
+	for i, k := range h.Keys {
+		k, v := k, h.Pairs[k]
+		items[i] = item{pos: k.Pos(), end: v.End(), print: func() {
+			p.expr(k, parser.LOWEST)
+			p.write(": ")
+			p.expr(v, parser.LOWEST)
+		}}
+	}
+	p.list("{", "}", items, true, h.Token.Pos, h.Rbrace)
+}
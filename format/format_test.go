@@ -0,0 +1,176 @@
+package format
+
+import (
+	"errors"
+	"monkey/lexer"
+	"monkey/parser"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1", "let x = 1;\n"},
+		{"x+y*z", "x + y * z;\n"},
+		{"(x+y)*z", "(x + y) * z;\n"},
+		{"a-(b-c)", "a - (b - c);\n"},
+		{"(a-b)-c", "a - b - c;\n"},
+		{"(a||b)&&c%d<=e", "(a || b) && c % d <= e;\n"},
+		{"a||(b&&c)", "a || b && c;\n"},
+		{"-(a+b)", "-(a + b);\n"},
+		{"!-a", "!-a;\n"},
+		{"a=b=c", "a = b = c;\n"},
+		{"(a=1)+2", "(a = 1) + 2;\n"},
+		{"x+=1;arr[0]*=2", "x += 1;\narr[0] *= 2;\n"},
+		{"(f)(1)(2)", "f(1)(2);\n"},
+		{"(a+b)[0]", "(a + b)[0];\n"},
+		{"json . parse(s).a", "json.parse(s).a;\n"},
+		{"(a+b).c;-(a.b)", "(a + b).c;\n-a.b;\n"},
+		{"add(1,2*3)", "add(1, 2 * 3);\n"},
+		{"[1,2.50,\"a\",true]", "[1, 2.50, \"a\", true];\n"},
+		{`{"b":2}`, "{\"b\": 2};\n"},
+		{"{}", "{};\n"},
+		{`"a\u{9}\"b\"\u{1}"`, "\"a\\t\\\"b\\\"\\u{1}\";\n"},
+		{"let s = `two\nlines`", "let s = `two\nlines`;\n"},
+		{`"a ${x+1} \"${ {"k": "${y}"}["k"] }\" \$"`, `"a ${x + 1} \"${{"k": "${y}"}["k"]}\" $";` + "\n"},
+		{"let f=fn(){}", "let f = fn() {};\n"},
+		{
+			"let add = fn(a, b) { a + b };",
+			"let add = fn(a, b) {\n\ta + b\n};\n",
+		},
+		{
+			"if (x > 1) { return x; } else { let y = 2; y }",
+			"if (x > 1) {\n\treturn x;\n} else {\n\tlet y = 2;\n\ty\n}\n",
+		},
+		{
+			"while (i < 3) { i += 1; if (i == 2) { break } else { continue } }",
+			"while (i < 3) {\n\ti += 1;\n\tif (i == 2) {\n\t\tbreak;\n\t} else {\n\t\tcontinue;\n\t}\n}\n",
+		},
+		{
+			"for (x in xs) { print(x) }",
+			"for (x in xs) {\n\tprint(x)\n}\n",
+		},
+		{
+			// blank lines collapse to one, lines without blanks stay together
+			"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;",
+			"let a = 1;\n\nlet b = 2;\nlet c = 3;\n",
+		},
+		{
+			// lists starting on a new line are printed one element per line
+			"let h = {\n\"b\": [\n1, 2]};\nmap(xs, fn(x) {\n x })",
+			"let h = {\n\t\"b\": [\n\t\t1,\n\t\t2\n\t],\n};\nmap(xs, fn(x) {\n\tx\n});\n",
+		},
+		{
+			// a statement ending in } keeps its ; when the next one would continue it
+			"if (x) { 1 }; (a + b)(1); fn() { 2 }; [1]; if (y) { 3 }; -1; if (z) { 4 }; a",
+			"if (x) {\n\t1\n};\n(a + b)(1);\nfn() {\n\t2\n};\n[1];\nif (y) {\n\t3\n};\n-1;\nif (z) {\n\t4\n}\na;\n",
+		},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		out, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("format of %q failed: %s", tt.input, err)
+		}
+		if string(out) != tt.expected {
+			t.Errorf("wrong output for %q\nwant=%q\ngot= %q", tt.input, tt.expected, out)
+			continue
+		}
+
+		// formatting is idempotent
+		again, err := Source(out)
+		if err != nil {
+			t.Fatalf("format of formatted %q failed: %s", out, err)
+		}
+		if string(again) != string(out) {
+			t.Errorf("formatting %q is not idempotent\nfirst= %q\nsecond=%q", tt.input, out, again)
+		}
+
+		// & doesn't change the meaning of the program
+		if parse(t, tt.input) != parse(t, string(out)) {
+			t.Errorf("formatting %q changed the program to %q", tt.input, out)
+		}
+	}
+}
+
+func TestHashPairOrder(t *testing.T) {
+	// the ast keeps pairs in a map, their order comes from the source positions
+	for i := 0; i < 10; i++ {
+		out, err := Source([]byte(`{"b":2,"a":1,"c":3}`))
+		if err != nil {
+			t.Fatalf("format failed: %s", err)
+		}
+		if expected := "{\"b\": 2, \"a\": 1, \"c\": 3};\n"; string(out) != expected {
+			t.Fatalf("wrong pair order, want=%q, got=%q", expected, out)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"// header\n\nlet x=1", "// header\n\nlet x = 1;\n"},
+		{"let x=1 // one\nlet y=2 /* two */", "let x = 1; // one\nlet y = 2; /* two */\n"},
+		{"let x=1;\n\n// end\n", "let x = 1;\n\n// end\n"},
+		{"// only", "// only\n"},
+		{
+			// comments inside a statement move after it
+			"let x = /* one */ 1;",
+			"let x = 1; /* one */\n",
+		},
+		{
+			"fn() {\n// first\nx // value\n// last\n}",
+			"fn() {\n\t// first\n\tx // value\n\t// last\n}\n",
+		},
+		{"if (x) { /* nothing */ }", "if (x) {\n\t/* nothing */\n}\n"},
+		{
+			"let h = {\n// a\n\"a\": 1, // one\n\"b\": 2\n// end\n};",
+			"let h = {\n\t// a\n\t\"a\": 1, // one\n\t\"b\": 2,\n\t// end\n};\n",
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("format of %q failed: %s", tt.input, err)
+		}
+		if string(out) != tt.expected {
+			t.Errorf("wrong output for %q\nwant=%q\ngot= %q", tt.input, tt.expected, out)
+			continue
+		}
+		again, err := Source(out)
+		if err != nil {
+			t.Fatalf("format of formatted %q failed: %s", out, err)
+		}
+		if string(again) != string(out) {
+			t.Errorf("formatting %q is not idempotent\nfirst= %q\nsecond=%q", tt.input, out, again)
+		}
+	}
+}
+
+// parse returns the program printed by the ast, comparable between formatted & unformatted source
+// hash pairs print in source order so programs only differing in layout compare equal
+func parse(t *testing.T, src string) string {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse of %q failed: %v", src, p.Errors())
+	}
+	return program.String()
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source([]byte("let x 1;\nlet = 2;"))
+	var ferr *Error
+	if !errors.As(err, &ferr) || len(ferr.Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", err)
+	}
+	if ferr.Error() != "1:7: expected next token to be =, got INT instead\n2:5: expected next token to be IDENT, got = instead" {
+		t.Errorf("wrong error message: %q", ferr.Error())
+	}
+}
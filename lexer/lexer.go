@@ -1,23 +1,76 @@
 package lexer
 
-import "monkey/token"
+import (
+	"fmt"
+	"monkey/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type Lexer struct {
 	input        string
 	position     int
 	readPosition int
 	ch           byte
+
+	file   string
+	line   int // line of the current char
+	column int // column of the current char
+
+	keepComments bool
+	comments     []token.Comment // comments read since the last token
+
+	// braces open within each ${ interpolation of a string literal, innermost last
+	// the } closing an interpolation resumes the literal
+	interps []int
+	errors  []Error
+}
+
+// Error describes a malformed token, the lexer returns such tokens as ILLEGAL
+type Error struct {
+	Pos token.Position // position of the problem within the token
+	Msg string
+}
+
+func (e Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// Option configures optional behaviour of the lexer
+type Option func(*Lexer)
+
+// WithFile sets the file name recorded in the position of every token
+func WithFile(name string) Option {
+	return func(l *Lexer) {
+		l.file = name
+	}
+}
+
+// WithComments attaches the comments preceding every token to it instead of dropping them
+func WithComments() Option {
+	return func(l *Lexer) {
+		l.keepComments = true
+	}
 }
 
 // New function passes a string to be tokenized
 // instantiates the lexer and returns it
-func New(input string) *Lexer {
-	l := &Lexer{input: input}
+func New(input string, opts ...Option) *Lexer {
+	l := &Lexer{input: input, line: 1}
+	for _, opt := range opts {
+		opt(l)
+	}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -25,12 +78,49 @@ func (l *Lexer) readChar() {
 	}
 	l.position = l.readPosition
 	l.readPosition++
+	l.column++
+}
+
+// Errors returns the errors of the tokens read so far, in source order
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+func (l *Lexer) errorf(pos token.Position, format string, a ...interface{}) {
+	l.errors = append(l.errors, Error{Pos: pos, Msg: fmt.Sprintf(format, a...)})
+}
+
+// pos returns the position of the current char
+func (l *Lexer) pos() token.Position {
+	return token.Position{File: l.file, Line: l.line, Column: l.column, Offset: l.position}
 }
 
 // Nexttoken function returns the next token from a lexers input string
 func (l *Lexer) NextToken() token.Token {
+	if unterminated, ok := l.skipTrivia(); !ok {
+		return token.Token{Type: token.ILLEGAL, Literal: "/*", Pos: unterminated, End: l.pos(), Comments: l.takeComments()}
+	}
+	start := l.pos()
+	tok := l.scanToken()
+	tok.Pos = start
+	tok.End = l.pos()
+	if tok.Type == token.EOF {
+		tok.End = start
+	}
+	tok.Comments = l.takeComments()
+	return tok
+}
+
+func (l *Lexer) takeComments() []token.Comment {
+	comments := l.comments
+	l.comments = nil
+	return comments
+}
+
+// scanToken reads the token starting at the current char
+// leaving the lexer on the char right after it
+func (l *Lexer) scanToken() token.Token {
 	var tok token.Token
-	l.skipWhiteSpace()
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -40,9 +130,9 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.withAssign(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.withAssign(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
 			l.readChar()
@@ -51,13 +141,19 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.withAssign(token.ASTERISK, token.ASTERISK_ASSIGN)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.withAssign(token.SLASH, token.SLASH_ASSIGN)
+	case '%':
+		tok = l.withAssign(token.PERCENT, token.PERCENT_ASSIGN)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.withAssign(token.GT, token.GT_EQ)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.withAssign(token.LT, token.LT_EQ)
+	case '&':
+		tok = l.doubled(token.AND)
+	case '|':
+		tok = l.doubled(token.OR)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '(':
@@ -67,8 +163,18 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '{':
+		if n := len(l.interps); n > 0 {
+			l.interps[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interps); n > 0 {
+			if l.interps[n-1] == 0 {
+				l.interps = l.interps[:n-1]
+				return l.readString(true)
+			}
+			l.interps[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
@@ -76,9 +182,12 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RBRACKET, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case '"':
-		tok.Literal = l.readString()
-		tok.Type = token.STRING
+		return l.readString(false)
+	case '`':
+		return l.readRawString()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -88,8 +197,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -99,6 +207,25 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// withAssign returns the compound assignment or comparison token if the current char is followed by =
+func (l *Lexer) withAssign(tt, assign token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: assign, Literal: string(ch) + "="}
+	}
+	return newToken(tt, l.ch)
+}
+
+// doubled returns the token tt if the current char is repeated, as in &&, or ILLEGAL
+func (l *Lexer) doubled(tt token.TokenType) token.Token {
+	if l.peekChar() == l.ch {
+		l.readChar()
+		return token.Token{Type: tt, Literal: string(tt)}
+	}
+	return newToken(token.ILLEGAL, l.ch)
+}
+
 func newToken(tt token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tt, Literal: string(ch)}
 }
@@ -115,12 +242,36 @@ func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or a float with an optional fraction & exponent, e.g. 1.5e-3
+// a . or e is only part of the number if digits follow it
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	pos := l.position
+	tt := token.TokenType(token.INT)
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tt = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if (next == '+' || next == '-') && isDigit(l.peekCharAt(2)) || isDigit(next) {
+			tt = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+	return tt, l.input[pos:l.position]
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[pos:l.position]
 }
 
 func isDigit(ch byte) bool {
@@ -133,18 +284,215 @@ func (l *Lexer) skipWhiteSpace() {
 	}
 }
 
+// skipTrivia skips whitespace & comments up to the next token, keeping the comments if enabled
+// reports false with the start of the comment if a block comment is not terminated
+func (l *Lexer) skipTrivia() (token.Position, bool) {
+	for {
+		l.skipWhiteSpace()
+		if l.ch != '/' || l.peekChar() != '/' && l.peekChar() != '*' {
+			return token.Position{}, true
+		}
+
+		start, pos := l.pos(), l.position
+		terminated := true
+		if l.peekChar() == '/' {
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+		} else {
+			l.readChar()
+			l.readChar()
+			for !(l.ch == '*' && l.peekChar() == '/') && l.ch != 0 {
+				l.readChar()
+			}
+			if l.ch == 0 {
+				terminated = false
+				l.errorf(start, "unterminated block comment")
+			} else {
+				l.readChar()
+				l.readChar()
+			}
+		}
+
+		if l.keepComments {
+			text := strings.TrimRight(l.input[pos:l.position], " \t\r")
+			l.comments = append(l.comments, token.Comment{Text: text, Pos: start, End: l.pos()})
+		}
+		if !terminated {
+			return start, false
+		}
+	}
+}
+
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	return l.peekCharAt(1)
+}
+
+// peekCharAt returns the char n positions after the current one without consuming it
+func (l *Lexer) peekCharAt(n int) byte {
+	if l.position+n >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	return l.input[l.position+n]
+}
+
+// readString reads a "..." literal decoding its escape sequences
+// a literal with ${...} interpolations is read in parts, from the " or the } closing an
+// interpolation up to the next ${ or the closing ", resumed is set for the parts after a }
+// the token is ILLEGAL if the literal is not terminated or has an invalid escape
+func (l *Lexer) readString(resumed bool) token.Token {
+	start, pos := l.pos(), l.position
+	var value strings.Builder
+	valid, interpolated := true, false
+	l.readChar()
+	for !interpolated && l.ch != '"' {
+		switch {
+		case l.ch == 0:
+			l.errorf(start, "unterminated string literal")
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[pos:l.position]}
+		case l.ch == '\\':
+			if !l.readEscape(&value) {
+				valid = false
+			}
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			l.readChar()
+			l.interps = append(l.interps, 0)
+			interpolated = true
+		default:
+			value.WriteByte(l.ch)
+			l.readChar()
+		}
+	}
+	if !interpolated {
+		l.readChar()
+	}
+
+	tt := token.TokenType(token.STRING)
+	switch {
+	case !valid:
+		return token.Token{Type: token.ILLEGAL, Literal: l.input[pos:l.position]}
+	case resumed && interpolated:
+		tt = token.INTERP_MID
+	case resumed:
+		tt = token.INTERP_END
+	case interpolated:
+		tt = token.INTERP_START
+	}
+	return token.Token{Type: tt, Literal: value.String()}
+}
+
+// readEscape decodes the escape sequence starting at the current \\ into value
+// reports false if it is invalid, leaving the lexer after the part read
+func (l *Lexer) readEscape(value *strings.Builder) bool {
+	start := l.pos()
+	l.readChar()
+	switch l.ch {
+	case 'n':
+		value.WriteByte('\n')
+	case 't':
+		value.WriteByte('\t')
+	case 'r':
+		value.WriteByte('\r')
+	case '\\', '"', '$':
+		value.WriteByte(l.ch)
+	case 'u':
+		return l.readUnicodeEscape(start, value)
+	case 0:
+		// reported as an unterminated literal
+		return false
+	default:
+		r, size := utf8.DecodeRuneInString(l.input[l.position:])
+		l.errorf(start, "unknown escape sequence \\%c", r)
+		for i := 0; i < size; i++ {
+			l.readChar()
+		}
+		return false
+	}
+	l.readChar()
+	return true
 }
 
-func (l *Lexer) readString() string {
+// readUnicodeEscape decodes a \\u{...} escape of 1 to 6 hex digits, the lexer is on the u
+func (l *Lexer) readUnicodeEscape(start token.Position, value *strings.Builder) bool {
+	l.readChar()
+	if l.ch != '{' {
+		l.errorf(start, "invalid unicode escape, expected \\u{...}")
+		return false
+	}
 	l.readChar()
 	pos := l.position
-	for l.ch != '"' || l.ch == 0 {
+	for isHexDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[pos:l.position]
+	digits := l.input[pos:l.position]
+	if l.ch != '}' || len(digits) == 0 || len(digits) > 6 {
+		l.errorf(start, "invalid unicode escape, expected \\u{...} with 1 to 6 hex digits")
+		return false
+	}
+	l.readChar()
+
+	r, _ := strconv.ParseUint(digits, 16, 32)
+	if r > unicode.MaxRune || 0xD800 <= r && r < 0xE000 {
+		l.errorf(start, "invalid unicode code point %s", digits)
+		return false
+	}
+	value.WriteRune(rune(r))
+	return true
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// readRawString reads a `...` literal, it spans lines & has no escapes
+// carriage returns are dropped so the value doesn't depend on the line endings of the file
+func (l *Lexer) readRawString() token.Token {
+	start, pos := l.pos(), l.position
+	var value strings.Builder
+	l.readChar()
+	for l.ch != '`' {
+		if l.ch == 0 {
+			l.errorf(start, "unterminated raw string literal")
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[pos:l.position]}
+		}
+		if l.ch != '\r' {
+			value.WriteByte(l.ch)
+		}
+		l.readChar()
+	}
+	l.readChar()
+	return token.Token{Type: token.RAW_STRING, Literal: value.String()}
+}
+
+// Quote returns s as a "..." literal that reads back as s, escaping any ${
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			// not utf-8, kept as is
+			b.WriteByte(s[i])
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '$' && strings.HasPrefix(s[i+1:], "{"):
+			b.WriteString(`\$`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&b, `\u{%x}`, r)
+		default:
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	b.WriteByte('"')
+	return b.String()
 }
@@ -12,7 +12,7 @@ func TestNextToken(t *testing.T) {
 	x + y;
 	};
 	let result = add(five, ten);
-	!-/*5;
+	!-/ *5;
 	5 < 10 > 5;
 	if (5 < 10) {
 	return true;
@@ -25,7 +25,9 @@ func TestNextToken(t *testing.T) {
 	"foo bar"
 	[1, 2];
 	{"foo": "bar"}
-	}`
+	}
+	x += 1; x -= 1; x *= 2; x /= 2;
+	a <= b >= c % d && e || f %= 2`
 	tests := []struct {
 		expectedType    token.TokenType
 		expectedLiteral string
@@ -116,6 +118,35 @@ func TestNextToken(t *testing.T) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 		{token.RBRACE, "}"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.AND, "&&"},
+		{token.IDENT, "e"},
+		{token.OR, "||"},
+		{token.IDENT, "f"},
+		{token.PERCENT_ASSIGN, "%="},
+		{token.INT, "2"},
 		{token.EOF, ""},
 	}
 
@@ -131,3 +162,270 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestNumberLiterals(t *testing.T) {
+	input := "5 3.14 1.5e3 2E-2 7e+1 1. 1e x"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1.5e3"},
+		{token.FLOAT, "2E-2"},
+		{token.FLOAT, "7e+1"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.INT, "1"},
+		{token.IDENT, "e"},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - tokenLiteral wrong. expected: %q, got: %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"ab\" +\n\tfoo"
+	tests := []struct {
+		expectedType token.TokenType
+		pos          token.Position
+		end          token.Position
+	}{
+		{token.LET, token.Position{File: "a.mk", Line: 1, Column: 1, Offset: 0}, token.Position{File: "a.mk", Line: 1, Column: 4, Offset: 3}},
+		{token.IDENT, token.Position{File: "a.mk", Line: 1, Column: 5, Offset: 4}, token.Position{File: "a.mk", Line: 1, Column: 6, Offset: 5}},
+		{token.ASSIGN, token.Position{File: "a.mk", Line: 1, Column: 7, Offset: 6}, token.Position{File: "a.mk", Line: 1, Column: 8, Offset: 7}},
+		{token.INT, token.Position{File: "a.mk", Line: 1, Column: 9, Offset: 8}, token.Position{File: "a.mk", Line: 1, Column: 10, Offset: 9}},
+		{token.SEMICOLON, token.Position{File: "a.mk", Line: 1, Column: 10, Offset: 9}, token.Position{File: "a.mk", Line: 1, Column: 11, Offset: 10}},
+		{token.STRING, token.Position{File: "a.mk", Line: 2, Column: 3, Offset: 13}, token.Position{File: "a.mk", Line: 2, Column: 7, Offset: 17}},
+		{token.PLUS, token.Position{File: "a.mk", Line: 2, Column: 8, Offset: 18}, token.Position{File: "a.mk", Line: 2, Column: 9, Offset: 19}},
+		{token.IDENT, token.Position{File: "a.mk", Line: 3, Column: 2, Offset: 21}, token.Position{File: "a.mk", Line: 3, Column: 5, Offset: 24}},
+		{token.EOF, token.Position{File: "a.mk", Line: 3, Column: 5, Offset: 24}, token.Position{File: "a.mk", Line: 3, Column: 5, Offset: 24}},
+	}
+
+	l := New(input, WithFile("a.mk"))
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.pos {
+			t.Errorf("tests[%d] - pos wrong. expected: %+v, got: %+v", i, tt.pos, tok.Pos)
+		}
+		if tok.End != tt.end {
+			t.Errorf("tests[%d] - end wrong. expected: %+v, got: %+v", i, tt.end, tok.End)
+		}
+	}
+}
+
+func TestDots(t *testing.T) {
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "json"},
+		{token.DOT, "."},
+		{token.IDENT, "parse"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.IDENT, "a"},
+		{token.DOT, "."},
+		{token.IDENT, "b"},
+		{token.IDENT, "c"},
+		{token.DOT, "."},
+		{token.INT, "1"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.FLOAT, "1.5"},
+		{token.EOF, ""},
+	}
+
+	l := New("json.parse(x) a. b c.1 1.x 1.5")
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected: %q %q, got: %q %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// header\nlet x = 1; // trailing  \n/* block\n spanning */ x /* inline */ / 2 //"
+	expected := []struct {
+		expectedType token.TokenType
+		comments     []string
+	}{
+		{token.LET, []string{"// header"}},
+		{token.IDENT, nil},
+		{token.ASSIGN, nil},
+		{token.INT, nil},
+		{token.SEMICOLON, nil},
+		{token.IDENT, []string{"// trailing", "/* block\n spanning */"}},
+		{token.SLASH, []string{"/* inline */"}},
+		{token.INT, nil},
+		{token.EOF, []string{"//"}},
+	}
+
+	// comments are skipped by default
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Comments != nil {
+			t.Errorf("tests[%d] - comments kept without WithComments: %v", i, tok.Comments)
+		}
+	}
+
+	l = New(input, WithComments())
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if len(tok.Comments) != len(tt.comments) {
+			t.Fatalf("tests[%d] - wrong number of comments, want=%q, got=%v", i, tt.comments, tok.Comments)
+		}
+		for j, c := range tok.Comments {
+			if c.Text != tt.comments[j] {
+				t.Errorf("tests[%d] - wrong comment, want=%q, got=%q", i, tt.comments[j], c.Text)
+			}
+		}
+	}
+
+	l = New("x /* a\nb */ y", WithComments())
+	l.NextToken()
+	c := l.NextToken().Comments[0]
+	if c.Pos != (token.Position{Line: 1, Column: 3, Offset: 2}) || c.End != (token.Position{Line: 2, Column: 5, Offset: 11}) {
+		t.Errorf("wrong comment span, got %+v - %+v", c.Pos, c.End)
+	}
+
+	l = New("x /* open", WithComments())
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.ILLEGAL || tok.Literal != "/*" || tok.Pos.Column != 3 {
+		t.Errorf("expected ILLEGAL for an unterminated comment, got %+v", tok)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Errorf("expected EOF after an unterminated comment, got %+v", tok)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"a\tb\nc"`, token.STRING, "a\tb\nc"},
+		{`"say \"hi\" \\ bye\r"`, token.STRING, "say \"hi\" \\ bye\r"},
+		{`"\u{41}\u{e9}\u{1F600}"`, token.STRING, "Aé😀"},
+		{"\"two\nlines\"", token.STRING, "two\nlines"},
+		{"`raw \\n \"x\"\r\nline`", token.RAW_STRING, "raw \\n \"x\"\nline"},
+		{"``", token.RAW_STRING, ""},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("wrong token for %s, want=%s %q, got=%s %q", tt.input, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if len(l.Errors()) != 0 {
+			t.Errorf("unexpected errors for %s: %v", tt.input, l.Errors())
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("expected EOF after %s, got %+v", tt.input, tok)
+		}
+
+		// quoting the value reads back the same string
+		if tok := New(Quote(tt.expectedLiteral)).NextToken(); tok.Type != token.STRING || tok.Literal != tt.expectedLiteral {
+			t.Errorf("Quote(%q) reads back as %s %q", tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`x = "open`, "1:5: unterminated string literal"},
+		{"x = `open", "1:5: unterminated raw string literal"},
+		{`"ends in \`, "1:1: unterminated string literal"},
+		{`"a\qb"`, `1:3: unknown escape sequence \q`},
+		{`"\u41"`, `1:2: invalid unicode escape, expected \u{...}`},
+		{`"\u{}"`, `1:2: invalid unicode escape, expected \u{...} with 1 to 6 hex digits`},
+		{`"\u{1234567}"`, `1:2: invalid unicode escape, expected \u{...} with 1 to 6 hex digits`},
+		{`"\u{D800}"`, "1:2: invalid unicode code point D800"},
+		{"/* open", "1:1: unterminated block comment"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		illegal := false
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			illegal = illegal || tok.Type == token.ILLEGAL
+		}
+		if !illegal {
+			t.Errorf("expected an ILLEGAL token for %s", tt.input)
+		}
+		if len(l.Errors()) != 1 || l.Errors()[0].Error() != tt.expected {
+			t.Errorf("wrong errors for %s, want=%q, got=%v", tt.input, tt.expected, l.Errors())
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	input := `"a ${x + "${y}"} b ${ {"k": 1}["k"] }\${c}"`
+	expected := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INTERP_START, "a "},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.INTERP_START, ""},
+		{token.IDENT, "y"},
+		{token.INTERP_END, ""},
+		{token.INTERP_MID, " b "},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.INTERP_END, "${c}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token, want=%s %q, got=%s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	l = New(`"a ${x} b`)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+	if len(l.Errors()) != 1 || l.Errors()[0].Error() != "1:7: unterminated string literal" {
+		t.Errorf("wrong errors for an unterminated interpolated string: %v", l.Errors())
+	}
+}
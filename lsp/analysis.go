@@ -0,0 +1,336 @@
+package lsp
+
+import (
+	"errors"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"sort"
+)
+
+// scopeNames are the names shown for the scope of a symbol
+var scopeNames = map[compiler.SymbolScope]string{
+	compiler.GlobalScope:   "GlobalScope",
+	compiler.LocalScope:    "LocalScope",
+	compiler.FreeScope:     "FreeScope",
+	compiler.BuiltinScope:  "BuiltinScope",
+	compiler.FunctionScope: "FunctionScope",
+}
+
+// binding is a name introduced by a let, a function parameter or a for loop
+type binding struct {
+	name  string
+	kind  string // let, parameter or loop variable
+	scope compiler.SymbolScope
+	span  parser.Span // the identifier in the definition
+}
+
+// reference is an identifier resolved against the symbol table
+// definitions are references to their own binding
+type reference struct {
+	span    parser.Span
+	name    string
+	symbol  compiler.Symbol
+	binding *binding // nil for builtins
+}
+
+// scope mirrors a symbol table of the compiler, one per function literal & one for the program
+type scope struct {
+	table    *compiler.SymbolTable
+	outer    *scope
+	defs     map[string]*binding
+	bindings []*binding // in definition order
+	start    int        // offsets of the function literal, the global scope covers the whole document
+	end      int
+}
+
+// analysis is the result of parsing, resolving & compiling a document
+type analysis struct {
+	program     *ast.Program
+	diagnostics []parser.Diagnostic
+	refs        []*reference
+	scopes      []*scope
+	builtins    *object.Registry
+}
+
+func analyze(text, file string, builtins *object.Registry) *analysis {
+	p := parser.New(lexer.New(text, lexer.WithFile(file)))
+	program := p.ParseProgram()
+
+	global := &scope{
+		table: compiler.NewSymbolTableWithBuiltins(builtins),
+		defs:  map[string]*binding{},
+		start: 0,
+		end:   len(text),
+	}
+	a := &analysis{program: program, diagnostics: p.Errors(), scopes: []*scope{global}, builtins: builtins}
+	r := &resolver{analysis: a, scope: global}
+	r.walk(program)
+
+	// the compiler stops at its first error & would report the statements dropped by the parser
+	// so it only runs on documents that parse
+	if len(a.diagnostics) == 0 {
+		if err := compiler.NewWithBuiltins(builtins).Compile(program); err != nil {
+			var cerr *compiler.Error
+			if errors.As(err, &cerr) {
+				a.diagnostics = append(a.diagnostics, parser.Diagnostic{
+					Severity: parser.SeverityError,
+					Span:     wordSpan(text, cerr.Pos),
+					Message:  cerr.Message,
+				})
+			}
+		}
+	}
+	return a
+}
+
+// wordSpan extends pos over the identifier starting at it, compiler errors only carry a position
+func wordSpan(text string, pos token.Position) parser.Span {
+	end := pos
+	for end.Offset < len(text) && isIdentChar(text[end.Offset]) {
+		end.Offset++
+		end.Column++
+	}
+	if end.Offset == pos.Offset && end.Offset < len(text) {
+		end.Offset++
+		end.Column++
+	}
+	return parser.Span{Start: pos, End: end}
+}
+
+func isIdentChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '_'
+}
+
+// referenceAt returns the reference covering offset, a cursor right after an identifier counts
+func (a *analysis) referenceAt(offset int) *reference {
+	var found *reference
+	for _, ref := range a.refs {
+		if ref.span.Start.Offset <= offset && offset < ref.span.End.Offset {
+			return ref
+		}
+		if offset == ref.span.End.Offset {
+			found = ref
+		}
+	}
+	return found
+}
+
+// visible returns the bindings in scope at offset, innermost first, followed by the builtins
+// each item is described with the scope the name resolves to at offset
+func (a *analysis) visible(offset int) []CompletionItem {
+	innermost := a.scopes[0]
+	for _, s := range a.scopes[1:] {
+		if s.start <= offset && offset <= s.end && s.start >= innermost.start {
+			innermost = s
+		}
+	}
+
+	items := []CompletionItem{}
+	seen := map[string]bool{}
+	for s := innermost; s != nil; s = s.outer {
+		for i := len(s.bindings) - 1; i >= 0; i-- {
+			b := s.bindings[i]
+			if seen[b.name] || b.span.Start.Offset >= offset {
+				continue
+			}
+			seen[b.name] = true
+			sc := b.scope
+			if s != innermost && sc == compiler.LocalScope {
+				sc = compiler.FreeScope
+			}
+			items = append(items, CompletionItem{Label: b.name, Kind: CompletionVariable, Detail: b.kind + " · " + scopeNames[sc]})
+		}
+	}
+
+	names := a.builtins.Names()
+	sort.Strings(names)
+	for _, name := range names {
+		if !seen[name] {
+			items = append(items, CompletionItem{Label: name, Kind: CompletionFunction, Detail: "builtin · " + scopeNames[compiler.BuiltinScope]})
+		}
+	}
+	return items
+}
+
+// resolver walks the ast defining & resolving names the same way the compiler does
+type resolver struct {
+	*analysis
+	scope *scope
+}
+
+func (r *resolver) define(id *ast.Identifier, kind string) *binding {
+	sym := r.scope.table.Define(id.Value)
+	b := &binding{name: id.Value, kind: kind, scope: sym.Scope, span: nodeSpan(id)}
+	r.scope.defs[id.Value] = b
+	r.scope.bindings = append(r.scope.bindings, b)
+	r.refs = append(r.refs, &reference{span: b.span, name: id.Value, symbol: sym, binding: b})
+	return b
+}
+
+func (r *resolver) resolve(id *ast.Identifier) {
+	sym, ok := r.scope.table.Resolve(id.Value)
+	if !ok {
+		return
+	}
+	ref := &reference{span: nodeSpan(id), name: id.Value, symbol: sym}
+	for s := r.scope; s != nil && sym.Scope != compiler.BuiltinScope; s = s.outer {
+		if b, ok := s.defs[id.Value]; ok {
+			ref.binding = b
+			break
+		}
+	}
+	r.refs = append(r.refs, ref)
+}
+
+func nodeSpan(n ast.Node) parser.Span {
+	return parser.Span{Start: n.Pos(), End: n.End()}
+}
+
+func (r *resolver) walk(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			r.walk(s)
+		}
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, s := range node.Statements {
+			r.walk(s)
+		}
+	case *ast.LetStatement:
+		if node == nil || node.Name == nil {
+			return
+		}
+		b := r.define(node.Name, "let")
+		if fl, ok := node.Value.(*ast.FuncLiteral); ok && fl != nil {
+			r.walkFunc(fl, b)
+			return
+		}
+		r.walkExpr(node.Value)
+	case *ast.ReturnStatement:
+		if node != nil {
+			r.walkExpr(node.ReturnValue)
+		}
+	case *ast.ExpressionStatement:
+		if node != nil {
+			r.walkExpr(node.Expression)
+		}
+	case *ast.WhileStatement:
+		if node == nil {
+			return
+		}
+		r.walkExpr(node.Condition)
+		r.walk(node.Body)
+	case *ast.ForStatement:
+		if node == nil || node.Variable == nil {
+			return
+		}
+		r.walkExpr(node.Iterable)
+		r.define(node.Variable, "loop variable")
+		r.walk(node.Body)
+	}
+}
+
+func (r *resolver) walkExpr(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if exp != nil {
+			r.resolve(exp)
+		}
+	case *ast.PrefixExpression:
+		if exp != nil {
+			r.walkExpr(exp.Right)
+		}
+	case *ast.InfixExpression:
+		if exp != nil {
+			r.walkExpr(exp.Left)
+			r.walkExpr(exp.Right)
+		}
+	case *ast.AssignExpression:
+		if exp != nil {
+			r.walkExpr(exp.Target)
+			r.walkExpr(exp.Value)
+		}
+	case *ast.IfExpression:
+		if exp == nil {
+			return
+		}
+		r.walkExpr(exp.Condition)
+		r.walk(exp.Consequence)
+		if exp.Alternative != nil {
+			r.walk(exp.Alternative)
+		}
+	case *ast.FuncLiteral:
+		if exp != nil {
+			r.walkFunc(exp, nil)
+		}
+	case *ast.CallExpression:
+		if exp == nil {
+			return
+		}
+		r.walkExpr(exp.Function)
+		for _, arg := range exp.Arguments {
+			r.walkExpr(arg)
+		}
+	case *ast.ArrayLiteral:
+		if exp == nil {
+			return
+		}
+		for _, el := range exp.Elements {
+			r.walkExpr(el)
+		}
+	case *ast.InterpolatedString:
+		if exp == nil {
+			return
+		}
+		for _, e := range exp.Exprs {
+			r.walkExpr(e)
+		}
+	case *ast.IndexExpression:
+		if exp != nil {
+			r.walkExpr(exp.Left)
+			r.walkExpr(exp.Index)
+		}
+	case *ast.MemberExpression:
+		// members are looked up at run time, only the left side refers to a binding
+		if exp != nil {
+			r.walkExpr(exp.Left)
+		}
+	case *ast.HashLiteral:
+		if exp == nil {
+			return
+		}
+		for k, v := range exp.Pairs {
+			r.walkExpr(k)
+			r.walkExpr(v)
+		}
+	}
+}
+
+// walkFunc resolves the body of fl in a new scope, self is the let binding naming the function
+func (r *resolver) walkFunc(fl *ast.FuncLiteral, self *binding) {
+	s := &scope{
+		table: compiler.NewEnclosedSymbolTable(r.scope.table),
+		outer: r.scope,
+		defs:  map[string]*binding{},
+		start: fl.Pos().Offset,
+		end:   fl.End().Offset,
+	}
+	r.scopes = append(r.scopes, s)
+	r.scope = s
+	if fl.Name != "" && self != nil {
+		s.table.DefineFunctionName(fl.Name)
+		s.defs[fl.Name] = self
+	}
+	for _, p := range fl.Parameters {
+		r.define(p, "parameter")
+	}
+	r.walk(fl.Body)
+	r.scope = s.outer
+}
@@ -0,0 +1,102 @@
+package lsp
+
+import (
+	"monkey/object"
+	"monkey/parser"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is an open text document & the analysis of its current text
+type document struct {
+	uri      string
+	version  int
+	text     string
+	lines    []int // offsets of the start of every line
+	analysis *analysis
+}
+
+func newDocument(uri string, version int, text string, builtins *object.Registry) *document {
+	d := &document{uri: uri, version: version, text: text, lines: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	d.analysis = analyze(text, uri, builtins)
+	return d
+}
+
+// position converts a byte offset to a protocol position
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	return Position{Line: line, Character: utf16Len(d.text[d.lines[line]:offset])}
+}
+
+// offset converts a protocol position to a byte offset, positions past the end of a line are clamped to it
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	end := len(d.text)
+	if pos.Line+1 < len(d.lines) {
+		end = d.lines[pos.Line+1] - 1
+	}
+
+	offset, units := d.lines[pos.Line], 0
+	for offset < end && units < pos.Character {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		units += utf16RuneLen(r)
+		offset += size
+	}
+	return offset
+}
+
+func (d *document) rangeOf(span parser.Span) Range {
+	return Range{Start: d.position(span.Start.Offset), End: d.position(span.End.Offset)}
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	diags := []Diagnostic{}
+	for _, pd := range d.analysis.diagnostics {
+		msg := pd.Message
+		if pd.Expected != "" && !strings.Contains(msg, "expected") {
+			msg += " (expected " + pd.Expected + ")"
+		}
+		diags = append(diags, Diagnostic{
+			Range:    d.rangeOf(pd.Span),
+			Severity: severities[pd.Severity],
+			Source:   "monkey",
+			Message:  msg,
+		})
+	}
+	return diags
+}
+
+var severities = map[parser.Severity]DiagnosticSeverity{
+	parser.SeverityError:   SeverityError,
+	parser.SeverityWarning: SeverityWarning,
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+// utf16RuneLen returns the number of utf-16 code units encoding r
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,214 @@
+// Package lsp implements a language server for monkey over stdio
+//
+// it publishes parser & compiler errors as diagnostics and answers definition, hover &
+// completion requests from the same symbol tables the compiler uses
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/compiler"
+	"monkey/object"
+)
+
+// ErrNoShutdown is returned by Run when the client sent exit without a shutdown request first
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+// Server answers the requests of a single client
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	builtins *object.Registry
+	docs     map[string]*document
+
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:       bufio.NewReader(in),
+		out:      out,
+		builtins: object.DefaultRegistry(),
+		docs:     map[string]*document{},
+	}
+}
+
+// Run serves requests until the client sends exit or closes the input
+func (s *Server) Run() error {
+	for {
+		data, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if msg.ID == nil {
+			if msg.Method == "exit" {
+				if !s.shutdown {
+					return ErrNoShutdown
+				}
+				return nil
+			}
+			if err := s.handleNotification(msg.Method, msg.Params); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, rerr := s.handleRequest(msg.Method, msg.Params)
+		if err := s.reply(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	if rerr != nil {
+		return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, message{JSONRPC: "2.0", Method: method, Params: raw})
+}
+
+func (s *Server) handleRequest(method string, params json.RawMessage) (interface{}, *responseError) {
+	if method == "initialize" {
+		s.initialized = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:   syncFull,
+				DefinitionProvider: true,
+				HoverProvider:      true,
+				CompletionProvider: &CompletionOptions{},
+			},
+			ServerInfo: ServerInfo{Name: "monkey"},
+		}, nil
+	}
+	if !s.initialized {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	}
+
+	switch method {
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/definition":
+		return s.positionRequest(params, s.definition)
+	case "textDocument/hover":
+		return s.positionRequest(params, s.hover)
+	case "textDocument/completion":
+		return s.positionRequest(params, s.completion)
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", method)}
+}
+
+func (s *Server) handleNotification(method string, params json.RawMessage) error {
+	switch method {
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil
+		}
+		return s.update(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		// with full sync the last change holds the whole document
+		text := p.ContentChanges[len(p.ContentChanges)-1].Text
+		return s.update(p.TextDocument.URI, p.TextDocument.Version, text)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	}
+	// initialized, $/cancelRequest & other notifications need no answer
+	return nil
+}
+
+// update analyzes the new text of a document & publishes its diagnostics
+func (s *Server) update(uri string, version int, text string) error {
+	doc := newDocument(uri, version, text, s.builtins)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: doc.diagnostics(),
+	})
+}
+
+func (s *Server) positionRequest(params json.RawMessage, fn func(*document, int) interface{}) (interface{}, *responseError) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown document %s", p.TextDocument.URI)}
+	}
+	return fn(doc, doc.offset(p.Position)), nil
+}
+
+// definition returns the location of the let, parameter or loop variable the identifier at offset refers to
+func (s *Server) definition(doc *document, offset int) interface{} {
+	ref := doc.analysis.referenceAt(offset)
+	if ref == nil || ref.binding == nil {
+		return nil
+	}
+	return Location{URI: doc.uri, Range: doc.rangeOf(ref.binding.span)}
+}
+
+// hover describes the binding of the identifier at offset & the scope it resolves to there
+func (s *Server) hover(doc *document, offset int) interface{} {
+	ref := doc.analysis.referenceAt(offset)
+	if ref == nil {
+		return nil
+	}
+
+	kind := "builtin"
+	if ref.binding != nil {
+		kind = ref.binding.kind
+	}
+	if ref.symbol.Scope == compiler.FunctionScope {
+		kind = "function"
+	}
+	rng := doc.rangeOf(ref.span)
+	return Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: fmt.Sprintf("```monkey\n%s %s\n```\n%s", kind, ref.name, scopeNames[ref.symbol.Scope]),
+		},
+		Range: &rng,
+	}
+}
+
+func (s *Server) completion(doc *document, offset int) interface{} {
+	return CompletionList{Items: doc.analysis.visible(offset)}
+}
@@ -0,0 +1,393 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"monkey/object"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// client drives a server over pipes the way an editor would
+type client struct {
+	t        *testing.T
+	w        io.WriteCloser
+	msgs     chan map[string]json.RawMessage
+	done     chan error
+	nextID   int
+	received []map[string]json.RawMessage // notifications read while waiting for responses
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, msgs: make(chan map[string]json.RawMessage, 100), done: make(chan error, 1)}
+
+	go func() {
+		c.done <- NewServer(inR, outW).Run()
+		outW.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			data, err := readMessage(r)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			var msg map[string]json.RawMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("invalid message from server: %s", data)
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) send(msg interface{}) {
+	if err := writeMessage(c.w, msg); err != nil {
+		c.t.Fatalf("write failed: %s", err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// request sends a request & decodes the result of its response into result
+// returns the error of the response if any
+func (c *client) request(method string, params interface{}, result interface{}) *responseError {
+	c.nextID++
+	id := c.nextID
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+
+	for {
+		msg := c.next()
+		if msg["id"] == nil {
+			c.received = append(c.received, msg)
+			continue
+		}
+		var gotID int
+		if err := json.Unmarshal(msg["id"], &gotID); err != nil || gotID != id {
+			c.t.Fatalf("response to the wrong request, want=%d, got=%s", id, msg["id"])
+		}
+		if msg["error"] != nil {
+			var rerr responseError
+			json.Unmarshal(msg["error"], &rerr)
+			return &rerr
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg["result"], result); err != nil {
+				c.t.Fatalf("cannot decode result of %s: %s", method, err)
+			}
+		}
+		return nil
+	}
+}
+
+// diagnostics returns the next diagnostics published by the server
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	for {
+		var msg map[string]json.RawMessage
+		if len(c.received) > 0 {
+			msg, c.received = c.received[0], c.received[1:]
+		} else {
+			msg = c.next()
+		}
+		var method string
+		json.Unmarshal(msg["method"], &method)
+		if method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params PublishDiagnosticsParams
+		if err := json.Unmarshal(msg["params"], &params); err != nil {
+			c.t.Fatalf("cannot decode diagnostics: %s", err)
+		}
+		return params
+	}
+}
+
+func (c *client) next() map[string]json.RawMessage {
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatalf("server closed the connection")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for the server")
+	}
+	return nil
+}
+
+func (c *client) open(uri, text string) PublishDiagnosticsParams {
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "monkey", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func (c *client) shutdown() {
+	if err := c.request("shutdown", nil, nil); err != nil {
+		c.t.Fatalf("shutdown failed: %s", err)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Errorf("server stopped with an error: %s", err)
+	}
+}
+
+func initClient(t *testing.T) *client {
+	c := newClient(t)
+	var res InitializeResult
+	if err := c.request("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &res); err != nil {
+		t.Fatalf("initialize failed: %s", err)
+	}
+	if !res.Capabilities.HoverProvider || !res.Capabilities.DefinitionProvider || res.Capabilities.CompletionProvider == nil {
+		t.Fatalf("missing capabilities: %+v", res.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func at(uri string, line, char int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{Line: line, Character: char}}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := initClient(t)
+	defer c.shutdown()
+
+	diags := c.open("file:///a.mk", "let x 5;\nlet y = ;\nlet z = 1;")
+	expected := []Diagnostic{
+		{Range: Range{Position{0, 6}, Position{0, 7}}, Severity: SeverityError, Source: "monkey", Message: "expected next token to be =, got INT instead"},
+		{Range: Range{Position{1, 8}, Position{1, 9}}, Severity: SeverityError, Source: "monkey", Message: "no prefix parse function found for ; (expected expression)"},
+	}
+	if len(diags.Diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics, want=%d, got=%+v", len(expected), diags.Diagnostics)
+	}
+	for i, want := range expected {
+		if diags.Diagnostics[i] != want {
+			t.Errorf("wrong diagnostic %d, want=%+v, got=%+v", i, want, diags.Diagnostics[i])
+		}
+	}
+
+	// compiler errors are reported once the document parses
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: "file:///a.mk", Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let x = 5;\nx + missing;"}},
+	})
+	diags = c.diagnostics()
+	if len(diags.Diagnostics) != 1 || diags.Version != 2 {
+		t.Fatalf("wrong diagnostics, got=%+v", diags)
+	}
+	want := Diagnostic{Range: Range{Position{1, 4}, Position{1, 11}}, Severity: SeverityError, Source: "monkey", Message: "undefined variable missing"}
+	if diags.Diagnostics[0] != want {
+		t.Errorf("wrong diagnostic, want=%+v, got=%+v", want, diags.Diagnostics[0])
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: "file:///a.mk", Version: 3},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let x = 5;"}},
+	})
+	if diags = c.diagnostics(); len(diags.Diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got=%+v", diags.Diagnostics)
+	}
+}
+
+const program = `let total = 10;
+let add = fn(a, b) {
+  let sum = a + b;
+  fn() { sum + total + add(1, 2) }
+};
+for (item in [1]) { len(item) }
+`
+
+func TestDefinition(t *testing.T) {
+	c := initClient(t)
+	defer c.shutdown()
+	uri := "file:///def.mk"
+	c.open(uri, program)
+
+	tests := []struct {
+		pos      TextDocumentPositionParams
+		expected *Range
+	}{
+		{at(uri, 2, 12), &Range{Position{1, 13}, Position{1, 14}}}, // a, a parameter
+		{at(uri, 3, 9), &Range{Position{2, 6}, Position{2, 9}}},    // sum, a free variable
+		{at(uri, 3, 17), &Range{Position{0, 4}, Position{0, 9}}},   // total, a global
+		{at(uri, 3, 26), &Range{Position{1, 4}, Position{1, 7}}},   // add, the function itself
+		{at(uri, 5, 28), &Range{Position{5, 5}, Position{5, 9}}},   // item, the loop variable
+		{at(uri, 0, 5), &Range{Position{0, 4}, Position{0, 9}}},    // a definition points at itself
+		{at(uri, 5, 21), nil}, // len, a builtin
+		{at(uri, 0, 13), nil}, // a literal
+	}
+	for _, tt := range tests {
+		var loc *Location
+		if err := c.request("textDocument/definition", tt.pos, &loc); err != nil {
+			t.Fatalf("definition failed: %s", err)
+		}
+		switch {
+		case tt.expected == nil && loc != nil:
+			t.Errorf("expected no definition at %+v, got=%+v", tt.pos.Position, loc)
+		case tt.expected != nil && (loc == nil || loc.URI != uri || loc.Range != *tt.expected):
+			t.Errorf("wrong definition at %+v, want=%+v, got=%+v", tt.pos.Position, tt.expected, loc)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := initClient(t)
+	defer c.shutdown()
+	uri := "file:///hover.mk"
+	c.open(uri, program)
+	c.open(uri+"2", "let f = fn(n) { f(n) };")
+	c.open(uri+"3", `json.parse("1")`)
+
+	tests := []struct {
+		pos      TextDocumentPositionParams
+		expected string
+	}{
+		{at(uri, 0, 6), "let total\n```\nGlobalScope"},
+		{at(uri, 2, 13), "parameter a\n```\nLocalScope"},
+		{at(uri, 3, 10), "let sum\n```\nFreeScope"},
+		{at(uri, 3, 24), "let add\n```\nFreeScope"}, // captured by the closure
+		{at(uri+"2", 0, 16), "function f\n```\nFunctionScope"},
+		{at(uri, 5, 20), "builtin len\n```\nBuiltinScope"},
+		{at(uri+"3", 0, 1), "builtin json\n```\nBuiltinScope"},
+		{at(uri+"3", 0, 6), ""}, // members aren't bindings
+		{at(uri, 1, 0), ""},
+	}
+	for _, tt := range tests {
+		var hover *Hover
+		if err := c.request("textDocument/hover", tt.pos, &hover); err != nil {
+			t.Fatalf("hover failed: %s", err)
+		}
+		if tt.expected == "" {
+			if hover != nil {
+				t.Errorf("expected no hover at %+v, got=%+v", tt.pos.Position, hover)
+			}
+			continue
+		}
+		if hover == nil || hover.Contents.Value != "```monkey\n"+tt.expected {
+			t.Errorf("wrong hover at %+v, want=%q, got=%+v", tt.pos.Position, tt.expected, hover)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := initClient(t)
+	defer c.shutdown()
+	uri := "file:///complete.mk"
+	c.open(uri, program)
+
+	labels := func(pos TextDocumentPositionParams) map[string]string {
+		var list CompletionList
+		if err := c.request("textDocument/completion", pos, &list); err != nil {
+			t.Fatalf("completion failed: %s", err)
+		}
+		got := map[string]string{}
+		for _, item := range list.Items {
+			got[item.Label] = item.Detail
+		}
+		return got
+	}
+
+	// inside the closure, sum is free & item is not defined yet
+	got := labels(at(uri, 3, 9))
+	expected := map[string]string{
+		"sum":   "let · FreeScope",
+		"a":     "parameter · FreeScope",
+		"total": "let · GlobalScope",
+		"add":   "let · GlobalScope",
+		"len":   "builtin · BuiltinScope",
+	}
+	for name, detail := range expected {
+		if got[name] != detail {
+			t.Errorf("wrong completion for %s, want=%q, got=%q", name, detail, got[name])
+		}
+	}
+	if _, ok := got["item"]; ok {
+		t.Errorf("item completed before its definition")
+	}
+
+	// outside the function its parameters & locals are not in scope
+	got = labels(at(uri, 5, 20))
+	for _, name := range []string{"a", "sum"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("%s completed outside its function", name)
+		}
+	}
+	if got["item"] != "loop variable · GlobalScope" {
+		t.Errorf("wrong completion for item, got=%q", got["item"])
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	c := newClient(t)
+	if err := c.request("textDocument/hover", at("file:///x.mk", 0, 0), nil); err == nil || err.Code != codeServerNotInitialized {
+		t.Errorf("expected server not initialized error, got %v", err)
+	}
+	c.request("initialize", map[string]interface{}{}, nil)
+	if err := c.request("workspace/symbol", map[string]interface{}{}, nil); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("expected method not found error, got %v", err)
+	}
+	if err := c.request("textDocument/hover", at("file:///x.mk", 0, 0), nil); err == nil || err.Code != codeInvalidParams {
+		t.Errorf("expected invalid params for an unknown document, got %v", err)
+	}
+
+	// exit without shutdown is an error
+	c.notify("exit", nil)
+	if err := <-c.done; err != ErrNoShutdown {
+		t.Errorf("expected ErrNoShutdown, got %v", err)
+	}
+}
+
+func TestResponseKeys(t *testing.T) {
+	keys := func(msg map[string]json.RawMessage) []string {
+		var out []string
+		for k := range msg {
+			out = append(out, k)
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	c := newClient(t)
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "textDocument/hover", "params": at("file:///x.mk", 0, 0)})
+	msg := c.next()
+	if got := keys(msg); !reflect.DeepEqual(got, []string{"error", "id", "jsonrpc"}) {
+		t.Errorf("wrong keys in an error response: %v", got)
+	}
+
+	c.request("initialize", map[string]interface{}{}, nil)
+	// a successful request with no result still has one
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": 3, "method": "shutdown"})
+	msg = c.next()
+	if got := keys(msg); !reflect.DeepEqual(got, []string{"id", "jsonrpc", "result"}) || string(msg["result"]) != "null" {
+		t.Errorf("wrong keys in a null response: %v", got)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("server stopped with an error: %s", err)
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	text := "let s = \"héllo😀\"; let x = s;"
+	doc := newDocument("file:///u.mk", 1, text, object.DefaultRegistry())
+	offset := strings.Index(text, "; let") + 2
+	pos := doc.position(offset)
+	// é is 1 code unit, the emoji 2
+	if pos != (Position{0, 19}) {
+		t.Errorf("wrong position, want={0 19}, got=%+v", pos)
+	}
+	if doc.offset(pos) != offset {
+		t.Errorf("wrong offset, want=%d, got=%d", offset, doc.offset(pos))
+	}
+}
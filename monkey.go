@@ -0,0 +1,140 @@
+// Package monkey embeds the monkey interpreter in Go programs
+//
+//	in := monkey.New()
+//	in.RegisterFunc("greet", func(name string) string { return "hello " + name })
+//	in.Eval(`let rule = fn(x) { greet(x) };`)
+//	res, err := in.Call("rule", "monkey")
+package monkey
+
+import (
+	"fmt"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+// Interpreter compiles & runs monkey source on the vm
+// bindings defined by one call to Eval are visible to the following ones
+type Interpreter struct {
+	builtins    *object.Registry
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+}
+
+// ParseError holds the errors found while parsing the source passed to Eval
+type ParseError struct {
+	Errors []parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, d := range e.Errors {
+		msgs[i] = d.String()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// New returns an interpreter with the default builtins
+func New() *Interpreter {
+	return NewWithBuiltins(object.DefaultRegistry())
+}
+
+// NewWithBuiltins returns an interpreter exposing only the builtins of r
+// builtins registered on r after the interpreter is created are not visible to it
+func NewWithBuiltins(r *object.Registry) *Interpreter {
+	return &Interpreter{
+		builtins:    r,
+		symbolTable: compiler.NewSymbolTableWithBuiltins(r),
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalSize),
+	}
+}
+
+// Eval runs src & returns the value of its last expression statement
+// errors are a *ParseError, a *compiler.Error or a *vm.RuntimeError
+func (in *Interpreter) Eval(src string) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		return nil, &ParseError{Errors: errors}
+	}
+
+	comp := compiler.NewWithState(in.symbolTable, in.constants)
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	bc := comp.Bytecode()
+	in.constants = bc.Constants
+
+	machine := vm.NewWithState(bc, in.globals)
+	machine.SetBuiltins(in.builtins)
+	if err := machine.Run(); err != nil {
+		return nil, err
+	}
+	if res := machine.LastPoppedElem(); res != nil {
+		return res, nil
+	}
+	return vm.Null, nil
+}
+
+// Call calls the global function fnName with args converted by ToObject
+func (in *Interpreter) Call(fnName string, args ...interface{}) (object.Object, error) {
+	fn, ok := in.GetGlobal(fnName)
+	if !ok {
+		return nil, fmt.Errorf("undefined function %s", fnName)
+	}
+
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		objs[i] = obj
+	}
+
+	machine := vm.NewWithState(&compiler.Bytecode{Constants: in.constants}, in.globals)
+	machine.SetBuiltins(in.builtins)
+	return machine.Call(fn, objs...)
+}
+
+// SetGlobal binds name to value converted by ToObject, defining the global if needed
+func (in *Interpreter) SetGlobal(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return err
+	}
+
+	symbol, ok := in.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = in.symbolTable.Define(name)
+	}
+	if symbol.Index >= len(in.globals) {
+		return fmt.Errorf("too many globals to define %s", name)
+	}
+	in.globals[symbol.Index] = obj
+	return nil
+}
+
+// GetGlobal returns the value bound to the global name
+func (in *Interpreter) GetGlobal(name string) (object.Object, bool) {
+	symbol, ok := in.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope || in.globals[symbol.Index] == nil {
+		return nil, false
+	}
+	return in.globals[symbol.Index], true
+}
+
+// RegisterFunc makes the go function fn callable from monkey as the global name
+// see WrapFunc for the supported signatures
+func (in *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	builtin, err := WrapFunc(fn)
+	if err != nil {
+		return err
+	}
+	return in.SetGlobal(name, builtin)
+}
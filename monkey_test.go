@@ -0,0 +1,231 @@
+package monkey
+
+import (
+	"errors"
+	"fmt"
+	"monkey/compiler"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	in := New()
+	if _, err := in.Eval("let x = 2;"); err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	// bindings persist between calls
+	res, err := in.Eval("let double = fn(n) { n * 2 }; double(x) + 1")
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	if got := FromObject(res); got != int64(5) {
+		t.Errorf("wrong result, want=5, got=%v", got)
+	}
+
+	tests := []struct {
+		input string
+		check func(error) bool
+	}{
+		{"let = 1", func(err error) bool { var e *ParseError; return errors.As(err, &e) }},
+		{"undefined", func(err error) bool { var e *compiler.Error; return errors.As(err, &e) }},
+		{`1 + "a"`, func(err error) bool { var e *vm.RuntimeError; return errors.As(err, &e) }},
+	}
+	for _, tt := range tests {
+		if _, err := in.Eval(tt.input); !tt.check(err) {
+			t.Errorf("wrong error type for %q: %T (%v)", tt.input, err, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	in := New()
+	if err := in.SetGlobal("limit", 10); err != nil {
+		t.Fatalf("SetGlobal failed: %s", err)
+	}
+	if err := in.SetGlobal("tags", []string{"a", "b"}); err != nil {
+		t.Fatalf("SetGlobal failed: %s", err)
+	}
+	res, err := in.Eval(`let total = limit * len(tags); total`)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	if got := FromObject(res); got != int64(20) {
+		t.Errorf("wrong result, want=20, got=%v", got)
+	}
+
+	// setting an existing global updates it in place
+	if err := in.SetGlobal("limit", 1.5); err != nil {
+		t.Fatalf("SetGlobal failed: %s", err)
+	}
+	res, err = in.Eval("limit")
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	if got := FromObject(res); got != 1.5 {
+		t.Errorf("wrong result, want=1.5, got=%v", got)
+	}
+
+	total, ok := in.GetGlobal("total")
+	if !ok || FromObject(total) != int64(20) {
+		t.Errorf("wrong global total, got=%v (%v)", total, ok)
+	}
+	if _, ok := in.GetGlobal("missing"); ok {
+		t.Errorf("expected missing global to be undefined")
+	}
+	if _, ok := in.GetGlobal("len"); ok {
+		t.Errorf("builtins are not globals")
+	}
+	if err := in.SetGlobal("bad", make(chan int)); err == nil {
+		t.Errorf("expected error converting a channel")
+	}
+}
+
+func TestCall(t *testing.T) {
+	in := New()
+	_, err := in.Eval(`
+	let discount = fn(order) {
+		if (order["total"] > 100) { order["total"] / 10 } else { 0 }
+	};
+	let counter = fn() { let n = 0; fn() { n += 1 } }();
+	`)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+
+	res, err := in.Call("discount", map[string]int{"total": 250})
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	if got := FromObject(res); got != int64(25) {
+		t.Errorf("wrong result, want=25, got=%v", got)
+	}
+
+	for i := int64(1); i <= 2; i++ {
+		res, err := in.Call("counter")
+		if err != nil {
+			t.Fatalf("call failed: %s", err)
+		}
+		if got := FromObject(res); got != i {
+			t.Errorf("wrong result, want=%d, got=%v", i, got)
+		}
+	}
+
+	if _, err := in.Call("nope"); err == nil {
+		t.Errorf("expected error calling an undefined function")
+	}
+	_, err = in.Call("discount", 1)
+	var rerr *vm.RuntimeError
+	if !errors.As(err, &rerr) || rerr.Trace[len(rerr.Trace)-1].Function != "discount" {
+		t.Errorf("expected runtime error in discount, got %v", err)
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	in := New()
+	funcs := map[string]interface{}{
+		"greet": func(name string) string { return "hello " + name },
+		"sum": func(nums ...int) int {
+			s := 0
+			for _, n := range nums {
+				s += n
+			}
+			return s
+		},
+		"avg": func(nums []float64) float64 { return (nums[0] + nums[1]) / float64(len(nums)) },
+		"check": func(ok bool) error {
+			if !ok {
+				return errors.New("check failed")
+			}
+			return nil
+		},
+		"parse":  func(s string) (int, error) { var n int; _, err := fmt.Sscan(s, &n); return n, err },
+		"kind":   func(obj object.Object) string { return string(obj.Type()) },
+		"keys":   func(m map[string]int) int { return len(m) },
+		"any":    func(v interface{}) string { return fmt.Sprintf("%T", v) },
+		"nothin": func() {},
+		"small":  func(n int8) int8 { return n },
+	}
+	for name, fn := range funcs {
+		if err := in.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("RegisterFunc(%s) failed: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`greet("monkey")`, "hello monkey"},
+		{`sum()`, int64(0)},
+		{`sum(1, 2, 3)`, int64(6)},
+		{`avg([1, 2.0])`, 1.5},
+		{`check(true)`, nil},
+		{`parse("42")`, int64(42)},
+		{`kind(fn() {})`, "CLOSURE"},
+		{`keys({"a": 1, "b": 2})`, int64(2)},
+		{`any(1)`, "int64"},
+		{`nothin()`, nil},
+	}
+	for _, tt := range tests {
+		res, err := in.Eval(tt.input)
+		if err != nil {
+			t.Fatalf("eval of %q failed: %s", tt.input, err)
+		}
+		if got := FromObject(res); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong result for %q, want=%v, got=%v", tt.input, tt.expected, got)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`check(false)`, "check failed"},
+		{`greet(1)`, "argument 0: cannot use INTEGER as string"},
+		{`greet()`, "wrong number of arguments. got=0, want=1"},
+		{`small(300)`, "argument 0: 300 overflows int8"},
+		{`sum(1, "2")`, "argument 1: cannot use STRING as int"},
+	}
+	for _, tt := range errorTests {
+		_, err := in.Eval(tt.input)
+		var rerr *vm.RuntimeError
+		if !errors.As(err, &rerr) || rerr.Message != tt.expected {
+			t.Errorf("wrong error for %q, want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	for _, bad := range []interface{}{1, func() (int, int) { return 0, 0 }} {
+		if err := in.RegisterFunc("bad", bad); err == nil || !strings.HasPrefix(err.Error(), "cannot wrap") {
+			t.Errorf("expected error wrapping %T, got %v", bad, err)
+		}
+	}
+}
+
+func TestBuiltinSets(t *testing.T) {
+	sandbox := object.NewRegistry()
+	sandbox.Register("len", object.GetBuiltinByName("len"))
+	full := object.DefaultRegistry()
+	full.Register("env", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.String{Value: "prod"}
+	}})
+
+	sandboxed, trusted := NewWithBuiltins(sandbox), NewWithBuiltins(full)
+	if res, err := trusted.Eval(`env() + "/" + first(["a"])`); err != nil || FromObject(res) != "prod/a" {
+		t.Errorf("wrong result from the full set, got=%v (%v)", res, err)
+	}
+	if res, err := sandboxed.Eval(`len("abc")`); err != nil || FromObject(res) != int64(3) {
+		t.Errorf("wrong result from the sandboxed set, got=%v (%v)", res, err)
+	}
+	for _, input := range []string{`env()`, `first([1])`} {
+		var cerr *compiler.Error
+		if _, err := sandboxed.Eval(input); !errors.As(err, &cerr) {
+			t.Errorf("expected %q to be undefined in the sandbox, got %v", input, err)
+		}
+	}
+	if _, err := New().Eval(`env()`); err == nil {
+		t.Errorf("expected env to be undefined for the default builtins")
+	}
+}
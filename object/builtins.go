@@ -1,8 +1,12 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
 
-// Builtins is a slice of structs, Name: Builtin fn
+// Builtins is a slice of structs, Name: Builtin fn, the default set of a Registry
 // Slice is used to allow a stable iteration
 // Name is used to identify the fn
 var Builtins = []struct {
@@ -15,6 +19,51 @@ var Builtins = []struct {
 	{"last", &Builtin{Fn: lastBn}},
 	{"rest", &Builtin{Fn: restBn}},
 	{"push", &Builtin{Fn: pushBn}},
+	{"int", &Builtin{Fn: intBn}},
+	{"float", &Builtin{Fn: floatBn}},
+	{"map", &Builtin{CallFn: mapBn}},
+	{"filter", &Builtin{CallFn: filterBn}},
+	{"reduce", &Builtin{CallFn: reduceBn}},
+	{"sort", &Builtin{CallFn: sortBn}},
+	{"any", &Builtin{CallFn: anyBn}},
+	{"all", &Builtin{CallFn: allBn}},
+	{"find", &Builtin{CallFn: findBn}},
+	{"keys", &Builtin{Fn: keysBn}},
+	{"values", &Builtin{Fn: valuesBn}},
+	{"entries", &Builtin{Fn: entriesBn}},
+	{"has", &Builtin{Fn: hasBn}},
+	{"delete", &Builtin{Fn: deleteBn}},
+	{"merge", &Builtin{Fn: mergeBn}},
+	{"split", &Builtin{Fn: splitBn}},
+	{"join", &Builtin{Fn: joinBn}},
+	{"trim", &Builtin{Fn: trimBn}},
+	{"upper", &Builtin{Fn: upperBn}},
+	{"lower", &Builtin{Fn: lowerBn}},
+	{"contains", &Builtin{Fn: containsBn}},
+	{"startsWith", &Builtin{Fn: startsWithBn}},
+	{"endsWith", &Builtin{Fn: endsWithBn}},
+	{"replace", &Builtin{Fn: replaceBn}},
+	{"indexOf", &Builtin{Fn: indexOfBn}},
+	{"substr", &Builtin{Fn: substrBn}},
+	{"repeat", &Builtin{Fn: repeatBn}},
+	{"chars", &Builtin{Fn: charsBn}},
+	{"format", &Builtin{Fn: formatBn}},
+	{"abs", &Builtin{Fn: absBn}},
+	{"min", &Builtin{Fn: minBn}},
+	{"max", &Builtin{Fn: maxBn}},
+	{"pow", &Builtin{Fn: powBn}},
+	{"sqrt", &Builtin{Fn: sqrtBn}},
+	{"floor", &Builtin{Fn: floorBn}},
+	{"ceil", &Builtin{Fn: ceilBn}},
+	{"clamp", &Builtin{Fn: clampBn}},
+}
+
+// Modules are the modules of a Registry, registered after Builtins
+var Modules = []*Module{
+	{Name: "json", Members: map[string]Object{
+		"parse":     &Builtin{Fn: jsonParseBn},
+		"stringify": &Builtin{Fn: jsonStringifyBn},
+	}},
 }
 
 // GetBuiltinByName finds a builtin func from its name
@@ -39,9 +88,11 @@ func lenBn(args ...Object) Object {
 
 	switch arg := args[0].(type) {
 	case *String:
-		return &Integer{Value: int64(len(arg.Value))}
+		return &Integer{Value: int64(arg.Len())}
 	case *Array:
 		return &Integer{Value: int64(len(arg.Elements))}
+	case *Hash:
+		return &Integer{Value: int64(arg.Len())}
 	default:
 		return newError("argument to `len` not supported, got %s", args[0].Type())
 	}
@@ -105,3 +156,344 @@ func pushBn(args ...Object) Object {
 		return &Array{Elements: newArr}
 	}
 }
+
+// intBn converts a float, truncating towards zero, or parses a string as an integer
+func intBn(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *Integer:
+		return arg
+	case *Float:
+		return floatToInt(arg.Value)
+	case *String:
+		v, err := strconv.ParseInt(arg.Value, 10, 64)
+		if err != nil {
+			return newError("cannot convert %q to INTEGER", arg.Value)
+		}
+		return &Integer{Value: v}
+	default:
+		return newError("argument to `int` not supported, got %s", args[0].Type())
+	}
+}
+
+// floatBn converts an integer or parses a string as a float
+func floatBn(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *Integer:
+		return &Float{Value: float64(arg.Value)}
+	case *Float:
+		return arg
+	case *String:
+		v, err := strconv.ParseFloat(arg.Value, 64)
+		if err != nil {
+			return newError("cannot convert %q to FLOAT", arg.Value)
+		}
+		return &Float{Value: v}
+	default:
+		return newError("argument to `float` not supported, got %s", args[0].Type())
+	}
+}
+
+func handleHash(name string, expectedArgs int, args ...Object) (*Hash, Object) {
+	if len(args) != expectedArgs {
+		return nil, newError("wrong number of arguments. got=%d, want=%d", len(args), expectedArgs)
+	}
+	hash, ok := args[0].(*Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s", name, args[0].Type())
+	}
+	return hash, nil
+}
+
+func handleKey(key Object) (Hashable, Object) {
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return nil, newError("unusable as hash key: %s", key.Type())
+	}
+	return hashable, nil
+}
+
+// keysBn returns the keys of a hash in insertion order
+func keysBn(args ...Object) Object {
+	hash, errObj := handleHash("keys", 1, args...)
+	if errObj != nil {
+		return errObj
+	}
+	elements := make([]Object, hash.Len())
+	for i, pair := range hash.Pairs() {
+		elements[i] = pair.Key
+	}
+	return &Array{Elements: elements}
+}
+
+// valuesBn returns the values of a hash in the order of their keys
+func valuesBn(args ...Object) Object {
+	hash, errObj := handleHash("values", 1, args...)
+	if errObj != nil {
+		return errObj
+	}
+	elements := make([]Object, hash.Len())
+	for i, pair := range hash.Pairs() {
+		elements[i] = pair.Value
+	}
+	return &Array{Elements: elements}
+}
+
+// entriesBn returns the pairs of a hash as [key, value] arrays in insertion order
+func entriesBn(args ...Object) Object {
+	hash, errObj := handleHash("entries", 1, args...)
+	if errObj != nil {
+		return errObj
+	}
+	elements := make([]Object, hash.Len())
+	for i, pair := range hash.Pairs() {
+		elements[i] = &Array{Elements: []Object{pair.Key, pair.Value}}
+	}
+	return &Array{Elements: elements}
+}
+
+// hasBn reports whether the hash holds the key, even when its value is null
+func hasBn(args ...Object) Object {
+	hash, errObj := handleHash("has", 2, args...)
+	if errObj != nil {
+		return errObj
+	}
+	key, errObj := handleKey(args[1])
+	if errObj != nil {
+		return errObj
+	}
+	_, ok := hash.Get(key)
+	return nativeBool(ok)
+}
+
+// deleteBn returns a copy of the hash without the key, the hash itself is left as is
+func deleteBn(args ...Object) Object {
+	hash, errObj := handleHash("delete", 2, args...)
+	if errObj != nil {
+		return errObj
+	}
+	key, errObj := handleKey(args[1])
+	if errObj != nil {
+		return errObj
+	}
+	res := hash.Copy()
+	res.Delete(key)
+	return res
+}
+
+// mergeBn returns a new hash holding the pairs of all its arguments
+// a key in a later hash overrides the value of an earlier one & keeps its first place
+func mergeBn(args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	var res *Hash
+	for _, arg := range args {
+		hash, ok := arg.(*Hash)
+		if !ok {
+			return newError("argument to `merge` must be HASH, got %s", arg.Type())
+		}
+		if res == nil {
+			res = hash.Copy()
+			continue
+		}
+		for _, pair := range hash.Pairs() {
+			res.Set(pair.Key.(Hashable), pair.Value)
+		}
+	}
+	return res
+}
+
+// truthy matches the truthiness of conditions, only false & null are falsy
+func truthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return obj != nil
+	}
+}
+
+// mapBn returns a new array holding fn(el) for every element
+func mapBn(caller Caller, args ...Object) (Object, error) {
+	arr, errObj := handleArr(2, args...)
+	if errObj != nil {
+		return errObj, nil
+	}
+	elements := make([]Object, len(arr.Elements))
+	for i, el := range arr.Elements {
+		res, err := caller.Call(args[1], el)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = res
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// filterBn returns a new array holding the elements for which fn(el) is truthy
+func filterBn(caller Caller, args ...Object) (Object, error) {
+	arr, errObj := handleArr(2, args...)
+	if errObj != nil {
+		return errObj, nil
+	}
+	elements := []Object{}
+	for _, el := range arr.Elements {
+		res, err := caller.Call(args[1], el)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(res) {
+			elements = append(elements, el)
+		}
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// reduceBn folds the elements from the left with fn(acc, el)
+// without an initial value the first element is used, reducing an empty array then is an error
+func reduceBn(caller Caller, args ...Object) (Object, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=2 or 3", len(args)), nil
+	}
+	arr, errObj := handleArr(len(args), args...)
+	if errObj != nil {
+		return errObj, nil
+	}
+	elements := arr.Elements
+	var acc Object
+	if len(args) == 3 {
+		acc = args[2]
+	} else if len(elements) > 0 {
+		acc, elements = elements[0], elements[1:]
+	} else {
+		return newError("reduce of empty array with no initial value"), nil
+	}
+	for _, el := range elements {
+		res, err := caller.Call(args[1], acc, el)
+		if err != nil {
+			return nil, err
+		}
+		acc = res
+	}
+	return acc, nil
+}
+
+// sortBn returns a new array holding the elements in a stable ascending order
+// cmp(a, b) returns true or a negative number if a goes before b, without it numbers
+// & strings are sorted by their natural order
+func sortBn(caller Caller, args ...Object) (Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args)), nil
+	}
+	arr, errObj := handleArr(len(args), args...)
+	if errObj != nil {
+		return errObj, nil
+	}
+
+	elements := make([]Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+	// the sort can't be stopped, once failed the remaining comparisons are skipped
+	var err error
+	less := func(a, b Object) bool {
+		if len(args) == 1 {
+			c, ok := Compare(a, b)
+			if !ok {
+				errObj = newError("cannot compare %s with %s", a.Type(), b.Type())
+			}
+			return c < 0
+		}
+		res, callErr := caller.Call(args[1], a, b)
+		if callErr != nil {
+			err = callErr
+			return false
+		}
+		switch res := res.(type) {
+		case *Boolean:
+			return res.Value
+		case *Integer:
+			return res.Value < 0
+		case *Float:
+			return res.Value < 0
+		default:
+			errObj = newError("comparator must return a BOOLEAN or a number, got %s", res.Type())
+			return false
+		}
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		return err == nil && errObj == nil && less(elements[i], elements[j])
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if errObj != nil {
+		return errObj, nil
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// search calls fn(el) for the elements in order until the truthiness of the result is want
+// returns the index of that element, -1 if there is none
+func search(caller Caller, want bool, args ...Object) (int, Object, error) {
+	arr, errObj := handleArr(2, args...)
+	if errObj != nil {
+		return -1, errObj, nil
+	}
+	for i, el := range arr.Elements {
+		res, err := caller.Call(args[1], el)
+		if err != nil {
+			return -1, nil, err
+		}
+		if truthy(res) == want {
+			return i, nil, nil
+		}
+	}
+	return -1, nil, nil
+}
+
+// anyBn returns true if fn(el) is truthy for some element, stopping at the first one
+func anyBn(caller Caller, args ...Object) (Object, error) {
+	i, errObj, err := search(caller, true, args...)
+	if errObj != nil || err != nil {
+		return errObj, err
+	}
+	return nativeBool(i >= 0), nil
+}
+
+// allBn returns true if fn(el) is truthy for every element, stopping at the first falsy one
+func allBn(caller Caller, args ...Object) (Object, error) {
+	i, errObj, err := search(caller, false, args...)
+	if errObj != nil || err != nil {
+		return errObj, err
+	}
+	return nativeBool(i < 0), nil
+}
+
+// findBn returns the first element for which fn(el) is truthy, null if there is none
+func findBn(caller Caller, args ...Object) (Object, error) {
+	i, errObj, err := search(caller, true, args...)
+	if errObj != nil || err != nil {
+		return errObj, err
+	}
+	if i < 0 {
+		return NULL, nil
+	}
+	return args[0].(*Array).Elements[i], nil
+}
+
+func nativeBool(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
@@ -0,0 +1,156 @@
+package object
+
+import (
+	"math"
+	"strings"
+)
+
+// Equal reports whether a & b hold equal values
+// numbers compare by value across integers & floats, strings by content, booleans & null by
+// value, arrays & hashes element by element, any other object such as a closure only equals itself
+func Equal(a, b Object) bool {
+	return equal(a, b, nil)
+}
+
+// visit is a pair of containers being compared, meeting it again through a cycle counts as equal
+type visit struct {
+	a, b Object
+}
+
+func equal(a, b Object, seen map[visit]bool) bool {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == b.Value
+		case *Float:
+			return float64(a.Value) == b.Value
+		}
+		return false
+	case *Float:
+		// not short cut by identity, NaN doesn't equal itself
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == float64(b.Value)
+		case *Float:
+			return a.Value == b.Value
+		}
+		return false
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		if a == b || seen[visit{a, b}] {
+			return true
+		}
+		seen = mark(seen, a, b)
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		if a == b || seen[visit{a, b}] {
+			return true
+		}
+		seen = mark(seen, a, b)
+		// equal hashes hold the same pairs whatever their order
+		for _, pa := range a.Pairs() {
+			vb, ok := b.Get(pa.Key.(Hashable))
+			if !ok || !equal(pa.Value, vb, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func mark(seen map[visit]bool, a, b Object) map[visit]bool {
+	if seen == nil {
+		seen = make(map[visit]bool)
+	}
+	seen[visit{a, b}] = true
+	return seen
+}
+
+// Compare orders a & b returning -1, 0 or +1 as a is less than, equal to or greater than b
+// numbers compare by value & strings lexicographically by bytes
+// ok is false if a & b are not ordered, i.e. other objects, mismatched types or NaN
+func Compare(a, b Object) (c int, ok bool) {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return compareInts(a.Value, b.Value), true
+		case *Float:
+			return compareFloats(float64(a.Value), b.Value)
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return compareFloats(a.Value, float64(b.Value))
+		case *Float:
+			return compareFloats(a.Value, b.Value)
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return strings.Compare(a.Value, b.Value), true
+		}
+	}
+	return 0, false
+}
+
+// IsNumber reports whether obj is an integer or a float
+func IsNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+// ToFloat returns the value of an integer or float object as a float64, 0 for other objects
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *Float:
+		return obj.Value
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) (int, bool) {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return 0, false
+	}
+	switch {
+	case a < b:
+		return -1, true
+	case a > b:
+		return 1, true
+	}
+	return 0, true
+}
@@ -0,0 +1,215 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonParseBn decodes a json text, objects become hashes keeping their key order
+// integral numbers that fit become integers & the others floats
+func jsonParseBn(args ...Object) Object {
+	strs, errObj := stringArgs("json.parse", 1, args)
+	if errObj != nil {
+		return errObj
+	}
+
+	src := strs[0]
+	dec := json.NewDecoder(strings.NewReader(src))
+	dec.UseNumber()
+	res, err := decodeJSON(dec)
+	if err == nil {
+		// only white space may follow the value
+		end := dec.InputOffset()
+		if _, err = dec.Token(); err == io.EOF {
+			return res
+		} else if err == nil {
+			rest := strings.TrimLeft(src[end:], " \t\r\n")
+			err = &jsonError{"unexpected data after the value", int64(len(src) - len(rest))}
+		}
+	}
+
+	var jerr *jsonError
+	var serr *json.SyntaxError
+	switch {
+	case errors.As(err, &jerr):
+		return newError("json.parse: %s at offset %d", jerr.msg, jerr.offset)
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return newError("json.parse: unexpected end of input at offset %d", len(src))
+	case errors.As(err, &serr) && strings.HasPrefix(serr.Error(), "unexpected end"):
+		// a truncated text is a syntax error at its end
+		return newError("json.parse: unexpected end of input at offset %d", serr.Offset)
+	case errors.As(err, &serr):
+		// the offset counts the bytes read, the last one being invalid
+		return newError("json.parse: %s at offset %d", serr, serr.Offset-1)
+	default:
+		return newError("json.parse: %s at offset %d", err, dec.InputOffset())
+	}
+}
+
+// jsonError is a decoding error found after the decoder read the offending token
+type jsonError struct {
+	msg    string
+	offset int64
+}
+
+func (e *jsonError) Error() string { return e.msg }
+
+func decodeJSON(dec *json.Decoder) (Object, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			elements := []Object{}
+			for dec.More() {
+				el, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, el)
+			}
+			_, err := dec.Token()
+			return &Array{Elements: elements}, err
+		}
+		// the decoder only returns the { delimiter here, it checks the others are balanced
+		hash := NewHash(0)
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			val, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&String{Value: key.(string)}, val)
+		}
+		_, err := dec.Token()
+		return hash, err
+	case json.Number:
+		if n, err := strconv.ParseInt(string(tok), 10, 64); err == nil {
+			return &Integer{Value: n}, nil
+		}
+		f, err := tok.Float64()
+		if err != nil {
+			return nil, &jsonError{"number " + string(tok) + " out of range", dec.InputOffset() - int64(len(tok))}
+		}
+		return &Float{Value: f}, nil
+	case string:
+		return &String{Value: tok}, nil
+	case bool:
+		return nativeBool(tok), nil
+	default:
+		return NULL, nil
+	}
+}
+
+// jsonStringifyBn encodes a value as json text, indented by a number of spaces or a string if given
+// hash keys that are not strings are encoded by their Inspect()
+func jsonStringifyBn(args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	indent := ""
+	if len(args) == 2 {
+		switch arg := args[1].(type) {
+		case *Integer:
+			if arg.Value < 0 || arg.Value > 10 {
+				return newError("json.stringify: indent must be 0 to 10 spaces, got %d", arg.Value)
+			}
+			indent = strings.Repeat(" ", int(arg.Value))
+		case *String:
+			indent = arg.Value
+		default:
+			return newError("argument 2 to `json.stringify` must be INTEGER or STRING, got %s", arg.Type())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := encodeJSON(&out, args[0], map[Object]bool{}); err != nil {
+		return newError("json.stringify: %s", err)
+	}
+	if indent == "" {
+		return &String{Value: out.String()}
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out.Bytes(), "", indent); err != nil {
+		return newError("json.stringify: %s", err)
+	}
+	return &String{Value: indented.String()}
+}
+
+// encodeJSON writes obj to out, active holds the containers being encoded to detect cycles
+func encodeJSON(out *bytes.Buffer, obj Object, active map[Object]bool) error {
+	switch obj := obj.(type) {
+	case *Null:
+		out.WriteString("null")
+	case *Boolean:
+		out.WriteString(strconv.FormatBool(obj.Value))
+	case *Integer:
+		out.WriteString(strconv.FormatInt(obj.Value, 10))
+	case *Float:
+		// Inspect keeps the fraction of whole floats so they decode back to floats
+		s := obj.Inspect()
+		if strings.ContainsAny(s, "IN") {
+			return errors.New("cannot encode " + s)
+		}
+		out.WriteString(s)
+	case *String:
+		encodeJSONString(out, obj.Value)
+	case *Array:
+		if active[obj] {
+			return errors.New("cannot encode a cyclic ARRAY")
+		}
+		active[obj] = true
+		defer delete(active, obj)
+
+		out.WriteByte('[')
+		for i, el := range obj.Elements {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := encodeJSON(out, el, active); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case *Hash:
+		if active[obj] {
+			return errors.New("cannot encode a cyclic HASH")
+		}
+		active[obj] = true
+		defer delete(active, obj)
+
+		out.WriteByte('{')
+		for i, pair := range obj.Pairs() {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			encodeJSONString(out, pair.Key.Inspect())
+			out.WriteByte(':')
+			if err := encodeJSON(out, pair.Value, active); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	default:
+		return errors.New("cannot encode a value of type " + string(obj.Type()))
+	}
+	return nil
+}
+
+func encodeJSONString(out *bytes.Buffer, s string) {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode ends the value with a new line
+	out.Truncate(out.Len() - 1)
+}
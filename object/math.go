@@ -0,0 +1,286 @@
+package object
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// CheckedAdd returns a + b wrapped around on overflow & whether it didn't overflow
+func CheckedAdd(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c < a) == (b < 0)
+}
+
+// CheckedSub returns a - b wrapped around on overflow & whether it didn't overflow
+func CheckedSub(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c > a) == (b < 0)
+}
+
+// CheckedMul returns a * b wrapped around on overflow & whether it didn't overflow
+func CheckedMul(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	// -1 * MinInt64 wraps to MinInt64 which divides back evenly
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return c, false
+	}
+	return c, c/b == a
+}
+
+// CheckedDiv returns a / b wrapped around on overflow & whether it didn't overflow
+// b must not be zero
+func CheckedDiv(a, b int64) (int64, bool) {
+	return a / b, a != math.MinInt64 || b != -1
+}
+
+// Random is the source of random & randomInt, reseeded by seed for reproducible runs
+// each registry made by DefaultRegistry has its own so programs seeding it don't affect each other
+type Random struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandom returns a source seeded with the current time
+func NewRandom() *Random {
+	return &Random{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Register adds random, randomInt & seed drawing from the source to reg
+func (r *Random) Register(reg *Registry) error {
+	for _, def := range []struct {
+		name string
+		fn   BuiltinFunction
+	}{
+		{"random", r.randomBn},
+		{"randomInt", r.randomIntBn},
+		{"seed", r.seedBn},
+	} {
+		if err := reg.Register(def.name, &Builtin{Fn: def.fn}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Seed seeds the source
+func (r *Random) Seed(seed int64) {
+	r.mu.Lock()
+	r.rnd.Seed(seed)
+	r.mu.Unlock()
+}
+
+func numberArg(name string, args []Object, i int) (float64, Object) {
+	switch arg := args[i].(type) {
+	case *Integer:
+		return float64(arg.Value), nil
+	case *Float:
+		return arg.Value, nil
+	default:
+		return 0, newError("argument %d to `%s` must be a number, got %s", i+1, name, args[i].Type())
+	}
+}
+
+// floatToInt converts a float holding a whole number to an integer
+func floatToInt(f float64) Object {
+	if math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f) >= math.MaxInt64 {
+		return newError("cannot convert %s to INTEGER", (&Float{Value: f}).Inspect())
+	}
+	return &Integer{Value: int64(f)}
+}
+
+func absBn(args ...Object) Object {
+	if errObj := checkArgs(1, args); errObj != nil {
+		return errObj
+	}
+	switch arg := args[0].(type) {
+	case *Integer:
+		if arg.Value == math.MinInt64 {
+			return newError("integer overflow: abs(%d)", arg.Value)
+		}
+		if arg.Value < 0 {
+			return &Integer{Value: -arg.Value}
+		}
+		return arg
+	case *Float:
+		return &Float{Value: math.Abs(arg.Value)}
+	default:
+		return newError("argument 1 to `abs` must be a number, got %s", arg.Type())
+	}
+}
+
+// extremum returns the argument ordered first by sign, the first of equal ones
+func extremum(name string, sign int, args []Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	var res Object
+	for i := range args {
+		if _, errObj := numberArg(name, args, i); errObj != nil {
+			return errObj
+		}
+		if c, ok := Compare(args[i], res); res == nil || ok && c == sign {
+			res = args[i]
+		} else if !ok {
+			// NaN is unordered, it wins like in math.Min & math.Max
+			return &Float{Value: math.NaN()}
+		}
+	}
+	return res
+}
+
+// minBn returns the smallest of its numbers, keeping its type
+func minBn(args ...Object) Object {
+	return extremum("min", -1, args)
+}
+
+// maxBn returns the largest of its numbers, keeping its type
+func maxBn(args ...Object) Object {
+	return extremum("max", 1, args)
+}
+
+// powBn raises a to the power b, an integer if both are integers & b isn't negative
+func powBn(args ...Object) Object {
+	if errObj := checkArgs(2, args); errObj != nil {
+		return errObj
+	}
+	base, baseOk := args[0].(*Integer)
+	exp, expOk := args[1].(*Integer)
+	if baseOk && expOk && exp.Value >= 0 {
+		res, b, n := int64(1), base.Value, exp.Value
+		for ok := true; n > 0; n >>= 1 {
+			if n&1 == 1 {
+				if res, ok = CheckedMul(res, b); !ok {
+					return newError("integer overflow: pow(%d, %d)", base.Value, exp.Value)
+				}
+			}
+			if n > 1 {
+				if b, ok = CheckedMul(b, b); !ok {
+					return newError("integer overflow: pow(%d, %d)", base.Value, exp.Value)
+				}
+			}
+		}
+		return &Integer{Value: res}
+	}
+
+	a, errObj := numberArg("pow", args, 0)
+	if errObj != nil {
+		return errObj
+	}
+	b, errObj := numberArg("pow", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	return &Float{Value: math.Pow(a, b)}
+}
+
+func sqrtBn(args ...Object) Object {
+	if errObj := checkArgs(1, args); errObj != nil {
+		return errObj
+	}
+	x, errObj := numberArg("sqrt", args, 0)
+	if errObj != nil {
+		return errObj
+	}
+	return &Float{Value: math.Sqrt(x)}
+}
+
+// floorBn returns the largest integer less than or equal to x
+func floorBn(args ...Object) Object {
+	return rounding("floor", math.Floor, args)
+}
+
+// ceilBn returns the smallest integer greater than or equal to x
+func ceilBn(args ...Object) Object {
+	return rounding("ceil", math.Ceil, args)
+}
+
+func rounding(name string, round func(float64) float64, args []Object) Object {
+	if errObj := checkArgs(1, args); errObj != nil {
+		return errObj
+	}
+	switch arg := args[0].(type) {
+	case *Integer:
+		return arg
+	case *Float:
+		return floatToInt(round(arg.Value))
+	default:
+		return newError("argument 1 to `%s` must be a number, got %s", name, arg.Type())
+	}
+}
+
+// clampBn returns x limited to the range lo to hi
+func clampBn(args ...Object) Object {
+	if errObj := checkArgs(3, args); errObj != nil {
+		return errObj
+	}
+	for i := range args {
+		if _, errObj := numberArg("clamp", args, i); errObj != nil {
+			return errObj
+		}
+	}
+	x, lo, hi := args[0], args[1], args[2]
+	if c, ok := Compare(lo, hi); !ok || c > 0 {
+		return newError("invalid range to `clamp`: %s to %s", lo.Inspect(), hi.Inspect())
+	}
+	if c, _ := Compare(x, lo); c < 0 {
+		return lo
+	}
+	if c, _ := Compare(x, hi); c > 0 {
+		return hi
+	}
+	return x
+}
+
+// randomBn returns a float in [0, 1)
+func (r *Random) randomBn(args ...Object) Object {
+	if errObj := checkArgs(0, args); errObj != nil {
+		return errObj
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Float{Value: r.rnd.Float64()}
+}
+
+// randomIntBn returns an integer in [0, n) or in [lo, hi)
+func (r *Random) randomIntBn(args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	bounds := make([]int64, len(args))
+	for i := range args {
+		n, errObj := intArg("randomInt", args, i)
+		if errObj != nil {
+			return errObj
+		}
+		bounds[i] = n
+	}
+	lo, hi := int64(0), bounds[0]
+	if len(bounds) == 2 {
+		lo, hi = bounds[0], bounds[1]
+	}
+	n, ok := CheckedSub(hi, lo)
+	if !ok || n <= 0 {
+		return newError("invalid range to `randomInt`: %d to %d", lo, hi)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Integer{Value: lo + r.rnd.Int63n(n)}
+}
+
+// seedBn seeds the source of random & randomInt so the following numbers are reproducible
+func (r *Random) seedBn(args ...Object) Object {
+	if errObj := checkArgs(1, args); errObj != nil {
+		return errObj
+	}
+	seed, errObj := intArg("seed", args, 0)
+	if errObj != nil {
+		return errObj
+	}
+	r.Seed(seed)
+	return nil
+}
@@ -2,17 +2,21 @@ package object
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"monkey/ast"
 	"monkey/code"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ       = "INTEGER"
+	FLOAT_OBJ         = "FLOAT"
 	STRING_OBJ        = "STRING"
 	ARRAY_OBJ         = "ARRAY"
 	BOOLEAN_OBJ       = "BOOLEAN"
@@ -23,7 +27,18 @@ const (
 	COMPILED_FUNC_OBJ = "COMPILED_FUNC"
 	CLOSURE_OBJ       = "CLOSURE"
 	BUILTIN_OBJ       = "BUILTIN"
+	MODULE_OBJ        = "MODULE"
 	HASH_OBJ          = "HASH"
+	BREAK_OBJ         = "BREAK"
+	CONTINUE_OBJ      = "CONTINUE"
+	ITERATOR_OBJ      = "ITERATOR"
+	UPVALUE_OBJ       = "UPVALUE"
+)
+
+// errors returned by the vm & the evaluator when a limit stops execution
+var (
+	ErrCanceled       = errors.New("execution canceled")
+	ErrBudgetExceeded = errors.New("execution budget exceeded")
 )
 
 type Object interface {
@@ -31,7 +46,10 @@ type Object interface {
 	Inspect() string
 }
 
+// Hashable is an object usable as a hash key
+// equal keys, see Equal, have the same HashKey but distinct keys may share one
 type Hashable interface {
+	Object
 	Hash() HashKey
 }
 
@@ -71,6 +89,18 @@ func (e *Environment) Set(k string, v Object) Object {
 	return v
 }
 
+// Assign updates an existing binding in the environment that defines it
+// returns false if k is not bound in this or any outer environment
+func (e *Environment) Assign(k string, v Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[k]; ok {
+			env.store[k] = v
+			return true
+		}
+	}
+	return false
+}
+
 type Integer struct {
 	Value int64
 }
@@ -81,6 +111,21 @@ func (i *Integer) Hash() HashKey {
 	return HashKey{Type: INTEGER_OBJ, Value: uint64(i.Value)}
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows a fraction or exponent so floats are told apart from integers
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type String struct {
 	Value string
 }
@@ -93,6 +138,29 @@ func (s *String) Hash() HashKey {
 	return HashKey{Type: STRING_OBJ, Value: h.Sum64()}
 }
 
+// Interpolate returns the string joining the Inspect() of parts, built in one allocation
+func Interpolate(parts []Object) *String {
+	strs := make([]string, len(parts))
+	size := 0
+	for i, part := range parts {
+		strs[i] = part.Inspect()
+		size += len(strs[i])
+	}
+	var b strings.Builder
+	b.Grow(size)
+	for _, s := range strs {
+		b.WriteString(s)
+	}
+	return &String{Value: b.String()}
+}
+
+// the vm & the evaluator share these, builtins return them for booleans & null
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
 type Boolean struct {
 	Value bool
 }
@@ -114,31 +182,173 @@ type Array struct {
 func (arr *Array) Type() ObjectType { return ARRAY_OBJ }
 func (arr *Array) Inspect() string {
 	var out bytes.Buffer
-	elements := []string{}
-	for _, el := range arr.Elements {
-		elements = append(elements, el.Inspect())
-	}
-	out.WriteString(fmt.Sprintf("[%s]", strings.Join(elements, ", ")))
+	inspect(&out, arr, nil)
 	return out.String()
 }
 
+// inspect writes the Inspect() of obj, active holds the containers being printed
+// a container met again inside itself is printed as [...] or {...} instead of recursing forever
+func inspect(out *bytes.Buffer, obj Object, active map[Object]bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		if active[obj] {
+			out.WriteString("[...]")
+			return
+		}
+		if active == nil {
+			active = make(map[Object]bool)
+		}
+		active[obj] = true
+		defer delete(active, obj)
+
+		out.WriteByte('[')
+		for i, el := range obj.Elements {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			inspect(out, el, active)
+		}
+		out.WriteByte(']')
+	case *Hash:
+		if active[obj] {
+			out.WriteString("{...}")
+			return
+		}
+		if active == nil {
+			active = make(map[Object]bool)
+		}
+		active[obj] = true
+		defer delete(active, obj)
+
+		out.WriteByte('{')
+		for i, pair := range obj.pairs {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(pair.Key.Inspect())
+			out.WriteString(": ")
+			inspect(out, pair.Value, active)
+		}
+		out.WriteByte('}')
+	default:
+		out.WriteString(obj.Inspect())
+	}
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
 }
 
+// Hash maps keys to values keeping the pairs in insertion order
+// a pair is found in constant time by the HashKey of its key, keys sharing a HashKey
+// are told apart by comparing them with Equal
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs   []HashPair
+	buckets map[HashKey][]int      // positions in pairs of the keys with each HashKey
+	keyOf   func(Hashable) HashKey // the HashKey a key is filed under, key.Hash() when nil
+}
+
+// NewHash returns an empty hash with room for size pairs
+func NewHash(size int) *Hash {
+	return newHash(size, nil)
+}
+
+// newHash returns an empty hash filing its keys under keyOf, tests use it to force collisions
+func newHash(size int, keyOf func(Hashable) HashKey) *Hash {
+	return &Hash{pairs: make([]HashPair, 0, size), buckets: make(map[HashKey][]int, size), keyOf: keyOf}
+}
+
+// find returns the HashKey of key & the position of its pair, -1 if it is not in the hash
+func (h *Hash) find(key Hashable) (HashKey, int) {
+	hk := key.Hash()
+	if h.keyOf != nil {
+		hk = h.keyOf(key)
+	}
+	for _, i := range h.buckets[hk] {
+		if Equal(h.pairs[i].Key, key) {
+			return hk, i
+		}
+	}
+	return hk, -1
+}
+
+// Get returns the value stored under key
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	if _, i := h.find(key); i >= 0 {
+		return h.pairs[i].Value, true
+	}
+	return nil, false
+}
+
+// Set stores value under key, a new key is added after the others & an existing one keeps its place
+func (h *Hash) Set(key Hashable, value Object) {
+	hk, i := h.find(key)
+	if i >= 0 {
+		h.pairs[i] = HashPair{Key: key, Value: value}
+		return
+	}
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+	h.buckets[hk] = append(h.buckets[hk], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// Delete removes the pair stored under key, reporting whether there was one
+func (h *Hash) Delete(key Hashable) bool {
+	hk, i := h.find(key)
+	if i < 0 {
+		return false
+	}
+	bucket := h.buckets[hk]
+	for j, pos := range bucket {
+		if pos == i {
+			bucket = append(bucket[:j], bucket[j+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(h.buckets, hk)
+	} else {
+		h.buckets[hk] = bucket
+	}
+
+	// the pairs after the deleted one move down a place
+	h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+	for _, bucket := range h.buckets {
+		for j, pos := range bucket {
+			if pos > i {
+				bucket[j] = pos - 1
+			}
+		}
+	}
+	return true
+}
+
+// Copy returns a new hash holding the same pairs, changing either leaves the other as is
+func (h *Hash) Copy() *Hash {
+	c := &Hash{pairs: append([]HashPair(nil), h.pairs...), buckets: make(map[HashKey][]int, len(h.buckets)), keyOf: h.keyOf}
+	for hk, bucket := range h.buckets {
+		c.buckets[hk] = append([]int(nil), bucket...)
+	}
+	return c
+}
+
+// Len returns the number of pairs
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns the pairs in insertion order, the slice must not be modified
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-	out.WriteString(fmt.Sprintf("{%s}", strings.Join(pairs, ", ")))
+	inspect(&out, h, nil)
 	return out.String()
 }
 
@@ -154,6 +364,63 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break & Continue signal loop control flow in the evaluator, like ReturnValue does for returns
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Iterator yields the elements of an array, the characters of a string or the keys of a hash
+type Iterator struct {
+	next func() (Object, bool)
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// Next returns the next item, the bool is false once the iterator is exhausted
+func (it *Iterator) Next() (Object, bool) {
+	return it.next()
+}
+
+// NewIterator creates an iterator over obj, returning false if obj is not iterable
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		i := 0
+		return &Iterator{next: func() (Object, bool) {
+			if i >= len(obj.Elements) {
+				return nil, false
+			}
+			i++
+			return obj.Elements[i-1], true
+		}}, true
+	case *String:
+		offset := 0
+		return &Iterator{next: func() (Object, bool) {
+			if offset >= len(obj.Value) {
+				return nil, false
+			}
+			r, size := utf8.DecodeRuneInString(obj.Value[offset:])
+			offset += size
+			return &String{Value: string(r)}, true
+		}}, true
+	case *Hash:
+		keys := make([]Object, 0, obj.Len())
+		for _, pair := range obj.Pairs() {
+			keys = append(keys, pair.Key)
+		}
+		return NewIterator(&Array{Elements: keys})
+	default:
+		return nil, false
+	}
+}
+
 type Error struct {
 	Message string
 }
@@ -161,6 +428,9 @@ type Error struct {
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "Error: " + e.Message }
 
+// Error lets the evaluator's Caller report an error object as a failed call
+func (e *Error) Error() string { return e.Message }
+
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
@@ -184,16 +454,18 @@ func (f *Function) Inspect() string {
 }
 
 type CompiledFunction struct {
+	Name         string // name the function was bound to, empty for anonymous functions
 	Instructions code.Instructions
 	NumLocals    int
 	NumArgs      int
+	SourceMap    code.SourceMap
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNC_OBJ }
 func (cf *CompiledFunction) Inspect() string  { return fmt.Sprintf("CompiledFunction[%p]", cf) }
 
 type Closure struct {
-	Free []Object
+	Free []*Upvalue
 	Fn   *CompiledFunction
 }
 
@@ -202,9 +474,78 @@ func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
 
+// Upvalue is a variable captured by a closure, shared by every closure capturing it
+// while the function declaring the variable runs, the upvalue is open & points at its stack slot
+// once that function returns it is closed & holds the value itself
+type Upvalue struct {
+	location *Object
+	closed   Object
+	Slot     int // stack slot of the variable while open
+}
+
+// NewOpenUpvalue creates an upvalue pointing at the stack slot holding the variable
+func NewOpenUpvalue(location *Object, slot int) *Upvalue {
+	return &Upvalue{location: location, Slot: slot}
+}
+
+// NewClosedUpvalue creates an upvalue holding val
+func NewClosedUpvalue(val Object) *Upvalue {
+	u := &Upvalue{closed: val, Slot: -1}
+	u.location = &u.closed
+	return u
+}
+
+func (u *Upvalue) Type() ObjectType { return UPVALUE_OBJ }
+func (u *Upvalue) Inspect() string  { return fmt.Sprintf("Upvalue[%s]", u.Get().Inspect()) }
+
+func (u *Upvalue) Get() Object    { return *u.location }
+func (u *Upvalue) Set(val Object) { *u.location = val }
+func (u *Upvalue) IsOpen() bool   { return u.location != &u.closed }
+
+// Close copies the variable out of the stack so it outlives its frame
+func (u *Upvalue) Close() {
+	u.closed = *u.location
+	u.location = &u.closed
+	u.Slot = -1
+}
+
+// Builtin is a function implemented in go
+// CallFn is used instead of Fn when set, for builtins calling functions of the program
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn     BuiltinFunction
+	CallFn CallerFunction
+}
+
+// Caller calls functions of the program on behalf of a builtin, the vm & the evaluator implement it
+// a failed call, e.g. an error raised in fn or a limit hit, returns an error
+type Caller interface {
+	Call(fn Object, args ...Object) (Object, error)
 }
 
+// CallerFunction is a builtin calling functions through caller
+// an error from caller has to be returned as is so the vm or evaluator can unwind
+// bad arguments are reported like any other builtin, with an error object
+type CallerFunction func(caller Caller, args ...Object) (Object, error)
+
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
+
+// Module groups builtins under a name, its members are selected with . or an index, e.g. json.parse
+// modules are shared by the registries holding them so programs can't modify them
+type Module struct {
+	Name    string
+	Members map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "module " + m.Name }
+
+// Member returns the member named by index, an error if there is none
+func (m *Module) Member(index Object) (Object, error) {
+	if name, ok := index.(*String); ok {
+		if member, ok := m.Members[name.Value]; ok {
+			return member, nil
+		}
+	}
+	return nil, fmt.Errorf("module %s has no member %s", m.Name, index.Inspect())
+}
@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"strconv"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +21,279 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{3, "3.0"},
+		{-2, "-2.0"},
+		{1e21, "1e+21"},
+		{0.000001, "1e-06"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong inspect for %g, want=%q, got=%q", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := DefaultRegistry()
+	// random, randomInt & seed come with the registry's own source
+	if n := len(Builtins) + 3 + len(Modules); r.Len() != n {
+		t.Fatalf("wrong number of builtins, want=%d, got=%d", n, r.Len())
+	}
+	if m, ok := r.Lookup("json"); !ok || m != Modules[0] {
+		t.Errorf("json module not registered")
+	}
+
+	double := &Builtin{Fn: func(args ...Object) Object { return args[0] }}
+	if err := r.Register("double", double); err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	if b, ok := r.Lookup("double"); !ok || b != double || r.At(r.Len()-1) != double {
+		t.Errorf("double not registered at the end")
+	}
+	// other registries are unaffected
+	if _, ok := DefaultRegistry().Lookup("double"); ok {
+		t.Errorf("registering modified the default builtins")
+	}
+
+	// replacing keeps the index
+	clone := r.Clone()
+	if err := clone.Register("len", double); err != nil {
+		t.Fatalf("register failed: %s", err)
+	}
+	if clone.At(0) != double || clone.Names()[0] != "len" {
+		t.Errorf("len not replaced in place")
+	}
+	if b, _ := r.Lookup("len"); b == double {
+		t.Errorf("registering on a clone modified the original")
+	}
+
+	full := NewRegistry()
+	for i := 0; i < MaxBuiltins; i++ {
+		if err := full.Register(strconv.Itoa(i), double); err != nil {
+			t.Fatalf("register failed: %s", err)
+		}
+	}
+	if err := full.Register("one_too_many", double); err == nil {
+		t.Errorf("expected error registering more than %d builtins", MaxBuiltins)
+	}
+}
+
+func TestRandomPerRegistry(t *testing.T) {
+	call := func(r *Registry, name string, args ...Object) Object {
+		b, _ := r.Lookup(name)
+		return b.(*Builtin).Fn(args...)
+	}
+	a, b, c := DefaultRegistry(), DefaultRegistry(), DefaultRegistry()
+	call(a, "seed", &Integer{Value: 7})
+	call(c, "seed", &Integer{Value: 7})
+	// seeding & drawing from b leaves the sequence of a alone
+	call(b, "seed", &Integer{Value: 8})
+	call(b, "random")
+	for i := 0; i < 3; i++ {
+		x, y := call(a, "randomInt", &Integer{Value: 1000}), call(c, "randomInt", &Integer{Value: 1000})
+		if !Equal(x, y) {
+			t.Fatalf("draw %d differs between registries seeded alike: %s, %s", i, x.Inspect(), y.Inspect())
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	fn := &Closure{Fn: &CompiledFunction{}}
+	cyclic1 := &Array{Elements: []Object{&Integer{Value: 1}}}
+	cyclic1.Elements = append(cyclic1.Elements, cyclic1)
+	cyclic2 := &Array{Elements: []Object{&Integer{Value: 1}}}
+	cyclic2.Elements = append(cyclic2.Elements, cyclic2)
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{&Float{Value: 1.5}, &Integer{Value: 1}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&String{Value: "a"}, &String{Value: "b"}, false},
+		{&String{Value: "1"}, &Integer{Value: 1}, false},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Null{}, &Null{}, true},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, true},
+		{&Array{Elements: []Object{&Integer{Value: 1}}}, &Array{Elements: []Object{&Integer{Value: 2}}}, false},
+		{&Array{Elements: []Object{}}, &Array{Elements: []Object{&Integer{Value: 1}}}, false},
+		{hashOf("a", 1), hashOf("a", 1), true},
+		{hashOf("a", 1), hashOf("a", 2), false},
+		{hashOf("a", 1), hashOf("b", 1), false},
+		{fn, fn, true},
+		{fn, &Closure{Fn: fn.Fn}, false},
+		{cyclic1, cyclic2, true},
+		{&Float{Value: math.NaN()}, &Float{Value: math.NaN()}, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d] - Equal(%s, %s) wrong, want=%t, got=%t", i, tt.a.Inspect(), tt.b.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b     Object
+		expected int
+		ok       bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 2}, -1, true},
+		{&Integer{Value: 2}, &Float{Value: 1.5}, 1, true},
+		{&Float{Value: 2}, &Integer{Value: 2}, 0, true},
+		{&String{Value: "abc"}, &String{Value: "abd"}, -1, true},
+		{&String{Value: "b"}, &String{Value: "abc"}, 1, true},
+		{&String{Value: "a"}, &Integer{Value: 1}, 0, false},
+		{&Boolean{Value: true}, &Boolean{Value: false}, 0, false},
+		{&Float{Value: math.NaN()}, &Integer{Value: 1}, 0, false},
+	}
+
+	for i, tt := range tests {
+		got, ok := Compare(tt.a, tt.b)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("tests[%d] - Compare(%s, %s) wrong, want=%d %t, got=%d %t", i, tt.a.Inspect(), tt.b.Inspect(), tt.expected, tt.ok, got, ok)
+		}
+	}
+}
+
+func hashOf(key string, value int64) *Hash {
+	k := &String{Value: key}
+	h := NewHash(1)
+	h.Set(k, &Integer{Value: value})
+	return h
+}
+
+func TestHashOrder(t *testing.T) {
+	h := NewHash(0)
+	for i, key := range []string{"c", "a", "b"} {
+		k := &String{Value: key}
+		h.Set(k, &Integer{Value: int64(i)})
+	}
+	if got := h.Inspect(); got != "{c: 0, a: 1, b: 2}" {
+		t.Errorf("wrong inspect, got %q", got)
+	}
+
+	// updating a key keeps its place
+	a := &String{Value: "a"}
+	h.Set(a, &Integer{Value: 10})
+	if got := h.Inspect(); got != "{c: 0, a: 10, b: 2}" {
+		t.Errorf("wrong inspect after update, got %q", got)
+	}
+
+	if !h.Delete(a) || h.Delete(a) {
+		t.Errorf("delete should report the key only the first time")
+	}
+	if _, ok := h.Get(a); ok || h.Len() != 2 {
+		t.Errorf("deleted key still present")
+	}
+	b := &String{Value: "b"}
+	if val, ok := h.Get(b); !ok || val.(*Integer).Value != 2 {
+		t.Errorf("wrong value for a key after a deleted one, got %v", val)
+	}
+	h.Set(a, &Integer{Value: 3})
+	if got := h.Inspect(); got != "{c: 0, b: 2, a: 3}" {
+		t.Errorf("wrong inspect after delete & insert, got %q", got)
+	}
+}
+
+func TestHashCollisions(t *testing.T) {
+	// every key collides
+	h := newHash(0, func(Hashable) HashKey { return HashKey{Type: STRING_OBJ, Value: 1} })
+	keys := []Hashable{&String{Value: "a"}, &String{Value: "b"}, &Integer{Value: 1}, &Boolean{Value: true}}
+	for i, k := range keys {
+		h.Set(k, &Integer{Value: int64(i)})
+	}
+	h.Set(&String{Value: "b"}, &Integer{Value: 10})
+	if got := h.Inspect(); got != "{a: 0, b: 10, 1: 2, true: 3}" {
+		t.Errorf("colliding keys overwrote each other, got %q", got)
+	}
+
+	if !h.Delete(&String{Value: "a"}) {
+		t.Fatalf("delete of a colliding key failed")
+	}
+	for i, k := range keys[1:] {
+		val, ok := h.Get(k)
+		if !ok {
+			t.Fatalf("no value for %s after delete", k.Inspect())
+		}
+		if expected := []int64{10, 2, 3}[i]; val.(*Integer).Value != expected {
+			t.Errorf("wrong value for %s, want=%d, got=%s", k.Inspect(), expected, val.Inspect())
+		}
+	}
+	if _, ok := h.Get(&String{Value: "c"}); ok {
+		t.Errorf("found a missing key sharing the hash key of others")
+	}
+
+	// a copy files its keys the same way
+	c := h.Copy()
+	c.Set(&String{Value: "c"}, &Integer{Value: 4})
+	if got := c.Inspect(); got != "{b: 10, 1: 2, true: 3, c: 4}" {
+		t.Errorf("wrong copy of colliding keys, got %q", got)
+	}
+	if _, ok := h.Get(&String{Value: "c"}); ok {
+		t.Errorf("setting a key in the copy changed the original")
+	}
+}
+
+func TestHashCopy(t *testing.T) {
+	h := NewHash(0)
+	h.Set(&String{Value: "a"}, &Integer{Value: 1})
+	h.Set(&String{Value: "b"}, &Integer{Value: 2})
+
+	c := h.Copy()
+	c.Delete(&String{Value: "a"})
+	c.Set(&String{Value: "c"}, &Integer{Value: 3})
+	if got := h.Inspect(); got != "{a: 1, b: 2}" {
+		t.Errorf("changing the copy changed the hash, got %q", got)
+	}
+	if got := c.Inspect(); got != "{b: 2, c: 3}" {
+		t.Errorf("wrong copy, got %q", got)
+	}
+	if _, ok := c.Get(&String{Value: "b"}); !ok {
+		t.Errorf("key missing from the copy")
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	const max, min = math.MaxInt64, math.MinInt64
+	tests := []struct {
+		op   func(a, b int64) (int64, bool)
+		a, b int64
+		ok   bool
+	}{
+		{CheckedAdd, max - 1, 1, true},
+		{CheckedAdd, max, 1, false},
+		{CheckedAdd, min, -1, false},
+		{CheckedAdd, min, max, true},
+		{CheckedSub, min + 1, 1, true},
+		{CheckedSub, min, 1, false},
+		{CheckedSub, max, -1, false},
+		{CheckedSub, -1, min, true},
+		{CheckedSub, 0, min, false},
+		{CheckedMul, max / 2, 2, true},
+		{CheckedMul, max/2 + 1, 2, false},
+		{CheckedMul, min, -1, false},
+		{CheckedMul, -1, min, false},
+		{CheckedMul, min, 1, true},
+		{CheckedMul, 0, min, true},
+		{CheckedDiv, min, -1, false},
+		{CheckedDiv, min, 1, true},
+	}
+
+	for i, tt := range tests {
+		if _, ok := tt.op(tt.a, tt.b); ok != tt.ok {
+			t.Errorf("tests[%d] (%d, %d) wrong overflow, want ok=%t", i, tt.a, tt.b, tt.ok)
+		}
+	}
+}
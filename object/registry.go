@@ -0,0 +1,95 @@
+package object
+
+import "fmt"
+
+// MaxBuiltins is the number of builtins & modules a registry can hold, OpGetBuiltin has a 1 byte operand
+const MaxBuiltins = 256
+
+// Registry is an ordered set of named builtins & modules
+// the compiler refers to a builtin by its index so the vm running the bytecode
+// has to use the registry it was compiled with
+type Registry struct {
+	names    []string
+	builtins []Object // *Builtin or *Module
+	index    map[string]int
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{index: make(map[string]int)}
+}
+
+// DefaultRegistry returns a new registry holding Builtins, the random builtins of its own Random & Modules
+// every call returns a separate registry, registering on it leaves the others unchanged
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, def := range Builtins {
+		r.Register(def.Name, def.Builtin)
+	}
+	NewRandom().Register(r)
+	for _, m := range Modules {
+		r.RegisterModule(m)
+	}
+	return r
+}
+
+// Register adds the builtin under name
+// registering an existing name replaces its builtin & keeps its index
+func (r *Registry) Register(name string, b *Builtin) error {
+	return r.add(name, b)
+}
+
+// RegisterModule adds the module under its name, replacing what was registered under it
+func (r *Registry) RegisterModule(m *Module) error {
+	return r.add(m.Name, m)
+}
+
+func (r *Registry) add(name string, obj Object) error {
+	if i, ok := r.index[name]; ok {
+		r.builtins[i] = obj
+		return nil
+	}
+	if len(r.builtins) >= MaxBuiltins {
+		return fmt.Errorf("too many builtins to register %s", name)
+	}
+	r.index[name] = len(r.builtins)
+	r.names = append(r.names, name)
+	r.builtins = append(r.builtins, obj)
+	return nil
+}
+
+// Lookup returns the builtin or module registered under name
+func (r *Registry) Lookup(name string) (Object, bool) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, false
+	}
+	return r.builtins[i], true
+}
+
+// At returns the builtin or module at index i, nil if out of range
+func (r *Registry) At(i int) Object {
+	if i < 0 || i >= len(r.builtins) {
+		return nil
+	}
+	return r.builtins[i]
+}
+
+// Names returns the registered names in index order
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+func (r *Registry) Len() int {
+	return len(r.builtins)
+}
+
+// Clone returns a copy of the registry that can be extended independently
+// the builtins themselves are shared, so is the source of the random builtins
+func (r *Registry) Clone() *Registry {
+	c := NewRegistry()
+	for i, name := range r.names {
+		c.add(name, r.builtins[i])
+	}
+	return c
+}
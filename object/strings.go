@@ -0,0 +1,287 @@
+package object
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// the string builtins count positions & lengths in runes, not bytes
+
+// Len returns the number of runes in s
+func (s *String) Len() int {
+	return utf8.RuneCountInString(s.Value)
+}
+
+// Index returns the rune at position i as a string, nil if i is out of range
+func (s *String) Index(i int64) *String {
+	if i < 0 {
+		return nil
+	}
+	for _, r := range s.Value {
+		if i == 0 {
+			return &String{Value: string(r)}
+		}
+		i--
+	}
+	return nil
+}
+
+func checkArgs(want int, args []Object) Object {
+	if len(args) != want {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), want)
+	}
+	return nil
+}
+
+// stringArg returns the value of args[i], an error if it isn't a string
+func stringArg(name string, args []Object, i int) (string, Object) {
+	s, ok := args[i].(*String)
+	if !ok {
+		return "", newError("argument %d to `%s` must be STRING, got %s", i+1, name, args[i].Type())
+	}
+	return s.Value, nil
+}
+
+// intArg returns the value of args[i], an error if it isn't an integer
+func intArg(name string, args []Object, i int) (int64, Object) {
+	n, ok := args[i].(*Integer)
+	if !ok {
+		return 0, newError("argument %d to `%s` must be INTEGER, got %s", i+1, name, args[i].Type())
+	}
+	return n.Value, nil
+}
+
+// stringArgs checks the number of arguments of a builtin taking only strings & returns their values
+func stringArgs(name string, want int, args []Object) ([]string, Object) {
+	if errObj := checkArgs(want, args); errObj != nil {
+		return nil, errObj
+	}
+	strs := make([]string, len(args))
+	for i := range args {
+		s, errObj := stringArg(name, args, i)
+		if errObj != nil {
+			return nil, errObj
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &String{Value: s}
+	}
+	return &Array{Elements: elements}
+}
+
+// splitBn splits s around every sep, into its runes if sep is empty
+func splitBn(args ...Object) Object {
+	strs, errObj := stringArgs("split", 2, args)
+	if errObj != nil {
+		return errObj
+	}
+	return stringArray(strings.Split(strs[0], strs[1]))
+}
+
+// joinBn concatenates the elements of an array with sep between them
+// elements that are not strings are joined by their Inspect() like in interpolation
+func joinBn(args ...Object) Object {
+	arr, errObj := handleArr(2, args...)
+	if errObj != nil {
+		return errObj
+	}
+	sep, errObj := stringArg("join", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	strs := make([]string, len(arr.Elements))
+	for i, el := range arr.Elements {
+		strs[i] = el.Inspect()
+	}
+	return &String{Value: strings.Join(strs, sep)}
+}
+
+// trimBn removes the leading & trailing white space
+func trimBn(args ...Object) Object {
+	strs, errObj := stringArgs("trim", 1, args)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.TrimSpace(strs[0])}
+}
+
+func upperBn(args ...Object) Object {
+	strs, errObj := stringArgs("upper", 1, args)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.ToUpper(strs[0])}
+}
+
+func lowerBn(args ...Object) Object {
+	strs, errObj := stringArgs("lower", 1, args)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.ToLower(strs[0])}
+}
+
+func containsBn(args ...Object) Object {
+	strs, errObj := stringArgs("contains", 2, args)
+	if errObj != nil {
+		return errObj
+	}
+	return nativeBool(strings.Contains(strs[0], strs[1]))
+}
+
+func startsWithBn(args ...Object) Object {
+	strs, errObj := stringArgs("startsWith", 2, args)
+	if errObj != nil {
+		return errObj
+	}
+	return nativeBool(strings.HasPrefix(strs[0], strs[1]))
+}
+
+func endsWithBn(args ...Object) Object {
+	strs, errObj := stringArgs("endsWith", 2, args)
+	if errObj != nil {
+		return errObj
+	}
+	return nativeBool(strings.HasSuffix(strs[0], strs[1]))
+}
+
+// replaceBn replaces every old in s with new
+func replaceBn(args ...Object) Object {
+	strs, errObj := stringArgs("replace", 3, args)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+}
+
+// indexOfBn returns the position of the first sub in s, -1 if there is none
+func indexOfBn(args ...Object) Object {
+	strs, errObj := stringArgs("indexOf", 2, args)
+	if errObj != nil {
+		return errObj
+	}
+	i := strings.Index(strs[0], strs[1])
+	if i > 0 {
+		i = utf8.RuneCountInString(strs[0][:i])
+	}
+	return &Integer{Value: int64(i)}
+}
+
+// substrBn returns the runes of s from start, up to length of them or to the end of s
+// a start or length past the end of s is cut to it
+func substrBn(args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+	s, errObj := stringArg("substr", args, 0)
+	if errObj != nil {
+		return errObj
+	}
+	start, errObj := intArg("substr", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	length := int64(math.MaxInt64)
+	if len(args) == 3 {
+		if length, errObj = intArg("substr", args, 2); errObj != nil {
+			return errObj
+		}
+	}
+	if start < 0 || length < 0 {
+		return newError("negative position in `substr`: %d, %d", start, length)
+	}
+
+	// find the byte offsets of the runes at start & start+length
+	from, to := len(s), len(s)
+	var n int64
+	for offset := range s {
+		if n == start {
+			from = offset
+		}
+		if n-start == length {
+			to = offset
+			break
+		}
+		n++
+	}
+	if from > to {
+		from = to
+	}
+	return &String{Value: s[from:to]}
+}
+
+// repeatBn returns s repeated n times
+func repeatBn(args ...Object) Object {
+	if errObj := checkArgs(2, args); errObj != nil {
+		return errObj
+	}
+	s, errObj := stringArg("repeat", args, 0)
+	if errObj != nil {
+		return errObj
+	}
+	n, errObj := intArg("repeat", args, 1)
+	if errObj != nil {
+		return errObj
+	}
+	if n < 0 {
+		return newError("negative count in `repeat`: %d", n)
+	}
+	if n > 0 && int64(len(s)) > math.MaxInt32/n {
+		return newError("result of `repeat` too long")
+	}
+	return &String{Value: strings.Repeat(s, int(n))}
+}
+
+// charsBn splits s into its runes
+func charsBn(args ...Object) Object {
+	strs, errObj := stringArgs("chars", 1, args)
+	if errObj != nil {
+		return errObj
+	}
+	return stringArray(strings.Split(strs[0], ""))
+}
+
+// formatBn replaces each {} in the format with the Inspect() of the next argument
+// {{ & }} stand for literal braces
+func formatBn(args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	format, errObj := stringArg("format", args, 0)
+	if errObj != nil {
+		return errObj
+	}
+
+	var b strings.Builder
+	next := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '{' && i+1 < len(format) && format[i+1] == '}':
+			if next >= len(args) {
+				return newError("too few arguments to `format`, got %d", len(args)-1)
+			}
+			b.WriteString(args[next].Inspect())
+			next++
+			i++
+		case (c == '{' || c == '}') && i+1 < len(format) && format[i+1] == c:
+			b.WriteByte(c)
+			i++
+		case c == '{' || c == '}':
+			return newError("unmatched %c in format", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if next != len(args) {
+		return newError("too many arguments to `format`, got %d, used %d", len(args)-1, next-1)
+	}
+	return &String{Value: b.String()}
+}
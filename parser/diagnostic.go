@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota + 1
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Span is the range of source a diagnostic refers to, End is exclusive
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+func tokenSpan(t token.Token) Span {
+	return Span{Start: t.Pos, End: t.End}
+}
+
+// Diagnostic is a problem found while parsing
+type Diagnostic struct {
+	Severity Severity
+	Span     Span
+	Message  string
+	Expected string // what the parser expected to find instead, empty if there is no single answer
+}
+
+// String returns the message prefixed with the start of the span, e.g. 1:7: expected next token to be =, got INT instead
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Span.Start, d.Message)
+}
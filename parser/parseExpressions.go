@@ -1,7 +1,6 @@
 package parser
 
 import (
-	"fmt"
 	"monkey/ast"
 	"monkey/token"
 	"strconv"
@@ -10,7 +9,7 @@ import (
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.curToken.Type)
+		p.noPrefixParseFnError(p.curToken)
 		return nil
 	}
 	leftExp := prefix()
@@ -28,9 +27,11 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function found for %s", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError(t token.Token) {
+	if p.lexError(t) {
+		return
+	}
+	p.errorf(tokenSpan(t), "expression", "no prefix parse function found for %s", t.Type)
 }
 
 // REGISTER PARSE FUNCTIONS
@@ -38,6 +39,7 @@ func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 func (p *Parser) registerPrefixParseFns() {
 	p.prefixParseFns[token.IDENT] = p.parseIdentifier
 	p.prefixParseFns[token.INT] = p.parseIntegerLiteral
+	p.prefixParseFns[token.FLOAT] = p.parseFloatLiteral
 	p.prefixParseFns[token.TRUE] = p.parseBoolean
 	p.prefixParseFns[token.FALSE] = p.parseBoolean
 	p.prefixParseFns[token.BANG] = p.parsePrefixExpression
@@ -46,6 +48,8 @@ func (p *Parser) registerPrefixParseFns() {
 	p.prefixParseFns[token.IF] = p.parseIfExpression
 	p.prefixParseFns[token.FUNCTION] = p.parseFuncLiteral
 	p.prefixParseFns[token.STRING] = p.parseStringLiteral
+	p.prefixParseFns[token.RAW_STRING] = p.parseStringLiteral
+	p.prefixParseFns[token.INTERP_START] = p.parseInterpolatedString
 	p.prefixParseFns[token.LBRACKET] = p.parseArrayLiteral
 	p.prefixParseFns[token.LBRACE] = p.parseHashLiteral
 }
@@ -55,12 +59,24 @@ func (p *Parser) registerInfixParseFns() {
 	p.infixParseFns[token.NOT_EQ] = p.parseInfixExpression
 	p.infixParseFns[token.LT] = p.parseInfixExpression
 	p.infixParseFns[token.GT] = p.parseInfixExpression
+	p.infixParseFns[token.LT_EQ] = p.parseInfixExpression
+	p.infixParseFns[token.GT_EQ] = p.parseInfixExpression
+	p.infixParseFns[token.AND] = p.parseInfixExpression
+	p.infixParseFns[token.OR] = p.parseInfixExpression
+	p.infixParseFns[token.PERCENT] = p.parseInfixExpression
 	p.infixParseFns[token.MINUS] = p.parseInfixExpression
 	p.infixParseFns[token.PLUS] = p.parseInfixExpression
 	p.infixParseFns[token.ASTERISK] = p.parseInfixExpression
 	p.infixParseFns[token.SLASH] = p.parseInfixExpression
 	p.infixParseFns[token.LPAREN] = p.parseCallExpression
 	p.infixParseFns[token.LBRACKET] = p.parseIndexExpression
+	p.infixParseFns[token.DOT] = p.parseMemberExpression
+	p.infixParseFns[token.ASSIGN] = p.parseAssignExpression
+	p.infixParseFns[token.PLUS_ASSIGN] = p.parseAssignExpression
+	p.infixParseFns[token.MINUS_ASSIGN] = p.parseAssignExpression
+	p.infixParseFns[token.ASTERISK_ASSIGN] = p.parseAssignExpression
+	p.infixParseFns[token.SLASH_ASSIGN] = p.parseAssignExpression
+	p.infixParseFns[token.PERCENT_ASSIGN] = p.parseAssignExpression
 }
 
 // PARSE FUNCTIONS
@@ -78,8 +94,20 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(tokenSpan(p.curToken), "", "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorf(tokenSpan(p.curToken), "", "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -113,13 +141,40 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseAssignExpression parses the value with a lower precedence than its own
+// so chained assignments group to the right, a = b = c is a = (b = c)
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   target,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil: // the target already failed to parse
+		return nil
+	default:
+		p.errorf(Span{Start: target.Pos(), End: target.End()}, "identifier or index expression", "cannot assign to %s", target.String())
+		return nil
+	}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(ASSIGN - 1)
+	return exp
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
-	return &ast.ArrayLiteral{Token: p.curToken, Elements: p.parseExpressionList(token.RBRACKET)}
+	arr := &ast.ArrayLiteral{Token: p.curToken}
+	arr.Elements = p.parseExpressionList(token.RBRACKET)
+	arr.Rbracket = p.curToken.Pos
+	return arr
 }
 
 func (p *Parser) parseCallExpression(funcL ast.Expression) ast.Expression {
 	ce := &ast.CallExpression{Token: p.curToken, Function: funcL}
 	ce.Arguments = p.parseExpressionList(token.RPAREN)
+	ce.Rparen = p.curToken.Pos
 	return ce
 }
 
@@ -131,9 +186,19 @@ func (p *Parser) parseIndexExpression(funcL ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	ie.Rbracket = p.curToken.Pos
 	return ie
 }
 
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	me := &ast.MemberExpression{Token: p.curToken, Left: left}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	me.Member = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return me
+}
+
 func (p *Parser) parseExpressionList(endingToken token.TokenType) []ast.Expression {
 	args := []ast.Expression{}
 	if p.peekTokenIs(endingToken) {
@@ -237,6 +302,32 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: tok, Value: tok.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken, Strings: []string{p.curToken.Literal}}
+	for !p.curTokenIs(token.INTERP_END) {
+		p.nextToken()
+		exp := p.parseExpression(LOWEST)
+		if exp == nil {
+			return nil
+		}
+		str.Exprs = append(str.Exprs, exp)
+
+		if !p.peekTokenIs(token.INTERP_MID) && !p.peekTokenIs(token.INTERP_END) {
+			if !p.lexError(p.peekToken) {
+				p.errorf(tokenSpan(p.peekToken), "}", "expected } to close the interpolation, got %s instead", p.peekToken.Type)
+			}
+			return nil
+		}
+		p.nextToken()
+		str.Strings = append(str.Strings, p.curToken.Literal)
+	}
+	// the closing " is the last char of the INTERP_END token
+	str.Rquote = p.curToken.End
+	str.Rquote.Column--
+	str.Rquote.Offset--
+	return str
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
@@ -252,10 +343,12 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 			return nil
 		}
 		hash.Pairs[key] = val
+		hash.Keys = append(hash.Keys, key)
 	}
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken.Pos
 	return hash
 
 }
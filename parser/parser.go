@@ -10,6 +10,9 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
+	OR
+	AND
 	EQUALS
 	LESSGREATER
 	SUM
@@ -20,16 +23,28 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.MINUS:    SUM,
-	token.PLUS:     SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.MINUS:           SUM,
+	token.PLUS:            SUM,
+	token.ASTERISK:        PRODUCT,
+	token.SLASH:           PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
+	token.DOT:             INDEX,
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -55,7 +70,13 @@ type Parser struct {
 	l         *lexer.Lexer
 	curToken  token.Token
 	peekToken token.Token
-	errors    []string
+	errors    []Diagnostic
+	comments  []token.Comment
+
+	// panicking is set by the first error of a statement, further errors are dropped until
+	// the parser resyncs at the next statement boundary so one mistake reports one error
+	panicking bool
+	depth     int // number of braces open up to & including curToken
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -64,7 +85,7 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []Diagnostic{},
 	}
 	// read 2 tokens so curToken & peekToken are both set
 	p.nextToken()
@@ -78,18 +99,82 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
-func (p *Parser) Errors() []string {
+// Errors returns the diagnostics of every statement that failed to parse, in source order
+func (p *Parser) Errors() []Diagnostic {
 	return p.errors
 }
 
+// errorf records an error unless the parser is already recovering from one
+func (p *Parser) errorf(span Span, expected string, format string, a ...interface{}) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.errors = append(p.errors, Diagnostic{
+		Severity: SeverityError,
+		Span:     span,
+		Message:  fmt.Sprintf(format, a...),
+		Expected: expected,
+	})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	if p.lexError(p.peekToken) {
+		return
+	}
+	p.errorf(tokenSpan(p.peekToken), string(t), "expected next token to be %s, got %s instead", t, p.peekToken.Type)
+}
+
+// lexError records the error the lexer found in t if it is ILLEGAL, reports whether there was one
+func (p *Parser) lexError(t token.Token) bool {
+	if t.Type != token.ILLEGAL {
+		return false
+	}
+	for _, err := range p.l.Errors() {
+		if t.Pos.Offset <= err.Pos.Offset && err.Pos.Offset < t.End.Offset {
+			p.errorf(Span{Start: err.Pos, End: t.End}, "", "%s", err.Msg)
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	p.comments = append(p.comments, p.peekToken.Comments...)
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		if p.depth > 0 {
+			p.depth--
+		}
+	}
+}
+
+// synchronize skips the rest of a statement that failed to parse in a block opened at depth
+// it stops at a ; or before the next let, return or closing brace of the block
+// reports whether it stopped on the closing brace of the block itself
+func (p *Parser) synchronize(depth int) bool {
+	p.panicking = false
+	for !p.curTokenIs(token.EOF) {
+		if p.depth < depth {
+			return true
+		}
+		if p.depth == depth {
+			if p.curTokenIs(token.SEMICOLON) {
+				return false
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.RBRACE, token.EOF:
+				return false
+			}
+		}
+		p.nextToken()
+	}
+	return false
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -98,11 +183,14 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 	for !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			p.synchronize(0)
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	program.Comments = p.comments
 	return program
 }
 
@@ -26,8 +26,8 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 
 	t.Errorf("parser has %d errors", len(errors))
-	for _, msg := range errors {
-		t.Errorf("parser error: %q", msg)
+	for _, d := range errors {
+		t.Errorf("parser error: %q", d)
 	}
 	t.FailNow()
 }
@@ -247,6 +247,29 @@ func TestIntergerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1.5e3;", 1500},
+		{"2E-2;", 0.02},
+	}
+
+	for _, tt := range tests {
+		program := initTests(tt.input, t)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		lit, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("expression is not *ast.FloatLiteral, got=%T", stmt.Expression)
+		}
+		if lit.Value != tt.expected {
+			t.Errorf("lit.Value not %g, got=%g", tt.expected, lit.Value)
+		}
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	input := "true;"
 	program := initTests(input, t)
@@ -357,6 +380,11 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"3 + 4; -5 * 5", "(3 + 4)((-5) * 5)"},
 		{"5 > 4 == 3 < 4", "((5 > 4) == (3 < 4))"},
 		{"5 < 4 != 3 > 4", "((5 < 4) != (3 > 4))"},
+		{"a <= b == c >= d", "((a <= b) == (c >= d))"},
+		{"a + b % c", "(a + (b % c))"},
+		{"a || b && c == d", "(a || (b && (c == d)))"},
+		{"a && b || c && d", "((a && b) || (c && d))"},
+		{"x = a || b", "(x = (a || b))"},
 		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))"},
 		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))"},
 		{"true", "true"},
@@ -370,6 +398,8 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"!(true == true)", "(!(true == true))"},
 		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
 		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
+		{"-json.parse(s).a[0]", "(-(((json.parse)(s).a)[0]))"},
+		{"a.b.c + d", "(((a.b).c) + d)"},
 	}
 
 	for _, tt := range tests {
@@ -578,6 +608,28 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	program := initTests(`"a ${x + 1} b ${"c"}"`, t)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	str, ok := stmt.Expression.(*ast.InterpolatedString)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.InterpolatedString. got=%T", stmt.Expression)
+	}
+	if len(str.Strings) != 3 || str.Strings[0] != "a " || str.Strings[1] != " b " || str.Strings[2] != "" {
+		t.Errorf("wrong strings, got %q", str.Strings)
+	}
+	if len(str.Exprs) != 2 {
+		t.Fatalf("wrong number of expressions, got %d", len(str.Exprs))
+	}
+	testInfixExpression(t, str.Exprs[0], "x", "+", 1)
+	if lit, ok := str.Exprs[1].(*ast.StringLiteral); !ok || lit.Value != "c" {
+		t.Errorf("wrong second expression, got %s", str.Exprs[1])
+	}
+	if str.End().Column != 22 {
+		t.Errorf("wrong end, got %s", str.End())
+	}
+}
+
 func TestParsingArrayLiteral(t *testing.T) {
 	in := "[1, 2*2, 3+3]"
 	program := initTests(in, t)
@@ -612,6 +664,25 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingMemberExpressions(t *testing.T) {
+	program := initTests("json.parse", t)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	me, ok := stmt.Expression.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.MemberExpression, got: %T", stmt.Expression)
+	}
+	if !testIdentifier(t, me.Left, "json") || me.Member.Value != "parse" {
+		t.Errorf("wrong member expression %s", me.String())
+	}
+
+	p := New(lexer.New("a.1"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for a member that is not an identifier")
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	in := `{"one": 1, "two": 2, "three": 3}`
 	program := initTests(in, t)
@@ -741,3 +812,203 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"let x = 1;\n  let = 2;", "2:7: expected next token to be IDENT, got = instead"},
+		{"1 +\n\n  ;", "3:3: no prefix parse function found for ;"},
+		{"let x = 1;\nlet s = \"open", "2:9: unterminated string literal"},
+		{`puts("a\qb")`, `1:8: unknown escape sequence \q`},
+		{`"a ${x y}"`, "1:8: expected } to close the interpolation, got IDENT instead"},
+		{`"a ${}"`, "1:6: no prefix parse function found for INTERP_END"},
+		{"puts(\"a\\qb\")", "1:8: unknown escape sequence \\q"},
+		{"let f = fn() { 1", "1:17: expected } to close the block opened at 1:14, got EOF instead"},
+		{"if (x) {\n  while (y) { 1 }\n", "3:1: expected } to close the block opened at 1:8, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+		if errors[0].String() != tt.expected {
+			t.Errorf("wrong error for %q, want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input string
+		start string
+		end   string
+	}{
+		{"foo", "1:1", "1:4"},
+		{"a + b * c", "1:1", "1:10"},
+		{"add(1,\n  2)", "1:1", "2:5"},
+		{"[1, 2][0]", "1:1", "1:10"},
+		{`{"a": 1}`, "1:1", "1:9"},
+		{"fn(x) {\n  x\n}", "1:1", "3:2"},
+		{"if (x) { 1 } else { 2 }", "1:1", "1:24"},
+	}
+
+	for _, tt := range tests {
+		program := initTests(tt.input, t)
+		stmt := program.Statements[0]
+		if stmt.Pos().String() != tt.start {
+			t.Errorf("wrong start for %q, want=%s, got=%s", tt.input, tt.start, stmt.Pos())
+		}
+		if stmt.End().String() != tt.end {
+			t.Errorf("wrong end for %q, want=%s, got=%s", tt.input, tt.end, stmt.End())
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := "while (x < y) { x; break; continue; }"
+	program := initTests(input, t)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements, got=%d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement, got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body does not contain 3 statements, got=%d", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not ast.BreakStatement, got=%T", stmt.Body.Statements[1])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[2] is not ast.ContinueStatement, got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := "for (x in [1, 2]) { x }"
+	program := initTests(input, t)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements, got=%d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement, got=%T", program.Statements[0])
+	}
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+	arr, ok := stmt.Iterable.(*ast.ArrayLiteral)
+	if !ok || len(arr.Elements) != 2 {
+		t.Fatalf("iterable is not an array of 2 elements, got=%s", stmt.Iterable)
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body does not contain 1 statement, got=%d", len(stmt.Body.Statements))
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "(x = 5)"},
+		{"x += y * 2", "(x += (y * 2))"},
+		{"x -= 1", "(x -= 1)"},
+		{"x *= 2", "(x *= 2)"},
+		{"x /= 2", "(x /= 2)"},
+		{"a = b = c", "(a = (b = c))"},
+		{"arr[1 + 1] = 3", "((arr[(1 + 1)]) = 3)"},
+		{`h["k"] += 1`, "((h[k]) += 1)"},
+	}
+
+	for _, tt := range tests {
+		program := initTests(tt.input, t)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.AssignExpression); !ok {
+			t.Fatalf("exp is not ast.AssignExpression, got=%T", stmt.Expression)
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	inputs := []string{"1 = 2", "f() = 1", "(a + b) += 1"}
+
+	for _, input := range inputs {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q, got none", input)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `let x 5;
+let ok = 1;
+let f = fn(a) {
+  let y = ;
+  a + y
+};
+if (x { 1 };
+let z = 1 + }
+return ok;`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	expected := []struct {
+		msg      string
+		start    string
+		end      string
+		expected string
+	}{
+		{"expected next token to be =, got INT instead", "1:7", "1:8", "="},
+		{"no prefix parse function found for ;", "4:11", "4:12", "expression"},
+		{"expected next token to be ), got { instead", "7:7", "7:8", ")"},
+		{"no prefix parse function found for }", "8:13", "8:14", "expression"},
+	}
+	errors := p.Errors()
+	if len(errors) != len(expected) {
+		t.Fatalf("wrong number of errors, want=%d, got=%d: %v", len(expected), len(errors), errors)
+	}
+	for i, want := range expected {
+		d := errors[i]
+		if d.Severity != SeverityError || d.Message != want.msg || d.Expected != want.expected ||
+			d.Span.Start.String() != want.start || d.Span.End.String() != want.end {
+			t.Errorf("wrong diagnostic %d, want=%+v, got=%+v", i, want, d)
+		}
+	}
+
+	// statements around the errors are still parsed
+	stmts := []string{"let ok = 1;", "let f = fn<f>(a) (a + y);", "return ok;"}
+	if len(program.Statements) != len(stmts) {
+		t.Fatalf("wrong number of statements, want=%d, got=%d: %q", len(stmts), len(program.Statements), program.String())
+	}
+	for i, want := range stmts {
+		if got := program.Statements[i].String(); got != want {
+			t.Errorf("wrong statement %d, want=%q, got=%q", i, want, got)
+		}
+	}
+
+	// an error at the end of a block does not swallow the brace closing it
+	p = New(lexer.New("let f = fn() { 1 + }; let g = 2;"))
+	program = p.ParseProgram()
+	if len(p.Errors()) != 1 || len(program.Statements) != 2 {
+		t.Errorf("wrong recovery from an error before }, errors=%v, program=%q", p.Errors(), program.String())
+	}
+}
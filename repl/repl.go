@@ -2,9 +2,11 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"monkey/compiler"
+	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
@@ -15,15 +17,14 @@ const PROMPT = ">> "
 
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
-	symbolTable := compiler.NewSymbolTable()
-	for i, builtin := range object.Builtins {
-		symbolTable.DefineBuiltin(i, builtin.Name)
-	}
+	// the registry is shared by every line so seeding the random builtins lasts
+	builtins := object.DefaultRegistry()
+	symbolTable := compiler.NewSymbolTableWithBuiltins(builtins)
 	globals := make([]object.Object, vm.GlobalSize)
 	constants := []object.Object{}
 
 	for {
-		fmt.Printf(PROMPT)
+		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
 			return
@@ -39,11 +40,6 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		// eval := evaluator.Eval(program, env)
-		// if eval != nil {
-		// 	io.WriteString(out, eval.Inspect()+"\n")
-		// }
-
 		comp := compiler.NewWithState(symbolTable, constants)
 		err := comp.Compile(program)
 		if err != nil {
@@ -54,9 +50,15 @@ func Start(in io.Reader, out io.Writer) {
 		constants = bc.Constants
 
 		machine := vm.NewWithState(bc, globals)
+		machine.SetBuiltins(builtins)
 		err = machine.Run()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			var rerr *vm.RuntimeError
+			if errors.As(err, &rerr) {
+				fmt.Fprintf(out, "Woops! Executing bytecode failed:\n%s", rerr.Traceback())
+			} else {
+				fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			}
 			continue
 		}
 		stackTop := machine.LastPoppedElem()
@@ -65,10 +67,41 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+// StartEval runs the repl with the tree-walking evaluator instead of the vm
+func StartEval(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	env := object.NewEnv()
+	builtins := object.DefaultRegistry()
+
+	for {
+		fmt.Fprint(out, PROMPT)
+		scanned := scanner.Scan()
+		if !scanned {
+			return
+		}
+
+		line := scanner.Text()
+		l := lexer.New(line)
+		p := parser.New(l)
+
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserErrors(out, p.Errors())
+			continue
+		}
+
+		// without limits there is no error
+		eval, _ := evaluator.EvalWithOptions(program, env, evaluator.Options{Builtins: builtins})
+		if eval != nil {
+			io.WriteString(out, eval.Inspect()+"\n")
+		}
+	}
+}
+
+func printParserErrors(out io.Writer, errors []parser.Diagnostic) {
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, "parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, d := range errors {
+		io.WriteString(out, "\t"+d.String()+"\n")
 	}
 }
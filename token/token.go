@@ -1,10 +1,53 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character of the token
+	End     Position // position immediately after the last character of the token
+
+	// Comments are the comments between the previous token & this one
+	// only set if the lexer keeps comments, see lexer.WithComments
+	Comments []Comment
+}
+
+// Comment is a // line or /* block */ comment, Text includes the comment markers
+type Comment struct {
+	Text string
+	Pos  Position // position of the first character of the comment
+	End  Position // position immediately after the comment, before the newline ending a line comment
+}
+
+// Position describes a location in the source input
+// Line and Column are 1-based, Offset is the 0-based byte offset
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+// IsValid reports whether the position holds line info
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position as file:line:col, the file is omitted if unset
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.File != "" {
+			return p.File
+		}
+		return "-"
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 const (
@@ -12,9 +55,17 @@ const (
 	EOF     = "EOF"
 
 	// identifiers & literals
-	IDENT  = "IDENT"
-	INT    = "INT"
-	STRING = "STRING"
+	IDENT      = "IDENT"
+	INT        = "INT"
+	FLOAT      = "FLOAT"
+	STRING     = "STRING"
+	RAW_STRING = "RAW_STRING" // `...`, Literal holds the value as for STRING
+
+	// parts of a "...${a}...${b}..." string literal, the tokens of a & b come in between
+	// Literal holds the text of the part without the delimiters
+	INTERP_START = "INTERP_START" // "...${
+	INTERP_MID   = "INTERP_MID"   // }...${
+	INTERP_END   = "INTERP_END"   // }..."
 
 	// operators
 	ASSIGN   = "="
@@ -23,15 +74,27 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 	LT       = "<"
 	GT       = ">"
+	LT_EQ    = "<="
+	GT_EQ    = ">="
 	EQ       = "=="
 	NOT_EQ   = "!="
+	AND      = "&&"
+	OR       = "||"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
 
 	// delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	DOT       = "."
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -48,16 +111,26 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 func LookupIdent(id string) TokenType {
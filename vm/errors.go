@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/code"
+	"monkey/token"
+)
+
+// RuntimeError is returned by Run when executing the bytecode fails
+// it records the failing instruction & the call stack at the time of the error
+type RuntimeError struct {
+	Message string
+	Op      code.Opcode  // opcode of the instruction that failed
+	Trace   []TraceFrame // active frames, outermost first
+	Err     error        // the underlying error
+}
+
+// TraceFrame describes a frame on the call stack
+type TraceFrame struct {
+	Function string         // name of the function, <main> for the top level program
+	Offset   int            // offset of the executing instruction in the function
+	Pos      token.Position // source position of the instruction, invalid if unknown
+}
+
+// Error returns the message prefixed with the position of the innermost frame when known
+func (e *RuntimeError) Error() string {
+	if len(e.Trace) > 0 {
+		if pos := e.Trace[len(e.Trace)-1].Pos; pos.IsValid() {
+			return fmt.Sprintf("%s: %s", pos, e.Message)
+		}
+	}
+	return e.Message
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Traceback formats the error like a python traceback, the most recent call last
+// consecutive identical frames, e.g. from deep recursion, are collapsed into a single line
+func (e *RuntimeError) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString("Traceback (most recent call last):\n")
+	for i := 0; i < len(e.Trace); {
+		line := e.Trace[i].String()
+		n := 1
+		for i+n < len(e.Trace) && e.Trace[i+n].String() == line {
+			n++
+		}
+		fmt.Fprintf(&out, "  %s\n", line)
+		if n > 1 {
+			fmt.Fprintf(&out, "  [previous line repeated %d more times]\n", n-1)
+		}
+		i += n
+	}
+
+	if def, err := code.Lookup(byte(e.Op)); err == nil {
+		fmt.Fprintf(&out, "RuntimeError: %s (in %s)\n", e.Message, def.Name)
+	} else {
+		fmt.Fprintf(&out, "RuntimeError: %s\n", e.Message)
+	}
+	return out.String()
+}
+
+func (f TraceFrame) String() string {
+	switch {
+	case !f.Pos.IsValid():
+		return fmt.Sprintf("offset %d, in %s", f.Offset, f.Function)
+	case f.Pos.File == "":
+		return fmt.Sprintf("line %d, column %d, in %s", f.Pos.Line, f.Pos.Column, f.Function)
+	default:
+		return fmt.Sprintf("File %q, line %d, column %d, in %s", f.Pos.File, f.Pos.Line, f.Pos.Column, f.Function)
+	}
+}
+
+// newRuntimeError captures the call stack for an error raised by the instruction at ip in the current frame
+// an error raised further down, in a function called back by a builtin, keeps its own trace
+func (vm *VM) newRuntimeError(err error, op code.Opcode, ip int) *RuntimeError {
+	if rerr, ok := err.(*RuntimeError); ok {
+		return rerr
+	}
+	trace := make([]TraceFrame, 0, vm.framesIndex)
+	for i := 0; i < vm.framesIndex; i++ {
+		frame := vm.frames[i]
+		// the main frame of a vm only used to call functions has nothing to show
+		if i == 0 && len(frame.Instructions()) == 0 {
+			continue
+		}
+		// frames below the current one are suspended on an OpCall, ip points at its 1 byte operand
+		offset := frame.ip - 1
+		if i == vm.framesIndex-1 {
+			offset = ip
+		}
+
+		name := frame.cl.Fn.Name
+		switch {
+		case i == 0:
+			name = "<main>"
+		case name == "":
+			name = "<anonymous>"
+		}
+		trace = append(trace, TraceFrame{Function: name, Offset: offset, Pos: frame.cl.Fn.SourceMap.Lookup(offset)})
+	}
+	return &RuntimeError{Message: err.Error(), Op: op, Trace: trace, Err: err}
+}
@@ -1,26 +1,44 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"time"
 )
 
 const (
 	StackSize  = 2048
 	GlobalSize = 65536
 	MaxFrames  = 1024
+
+	// checkInterval is the number of instructions executed between checks of the context
+	checkInterval = 1024
+)
+
+var (
+	ErrCanceled       = object.ErrCanceled
+	ErrBudgetExceeded = object.ErrBudgetExceeded
 )
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+// Limits bound a single run of the vm, zero values mean no limit
+type Limits struct {
+	MaxInstructions int64         // maximum number of instructions executed
+	Timeout         time.Duration // wall clock limit of the run
+}
+
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 type Frame struct {
 	cl          *object.Closure
 	ip          int
-	basePointer int // refers to the location in the stack before the func frame was pushed
+	basePointer int   // refers to the location in the stack before the func frame was pushed
+	loops       []int // stack pointers at the entry of the running loops, innermost last
 }
 
 func NewFrame(cl *object.Closure, basePointer int) *Frame {
@@ -44,10 +62,23 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	openUpvalues []*object.Upvalue // upvalues still pointing into the stack
+
+	builtins *object.Registry
+	checked  bool // integer overflow is an error instead of wrapping around
+
+	limits    Limits
+	ctx       context.Context
+	steps     int64 // instructions executed in the current run
+	running   bool  // set during Run & calls from outside of it
+	nextCheck int64 // step at which the limits are checked next
 }
 
+// New returns a vm running bc with a default registry of its own, see SetBuiltins
+// so that seeding the random builtins of one vm leaves the others alone
 func New(bc *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bc.Instructions}
+	mainFn := &object.CompiledFunction{Instructions: bc.Instructions, SourceMap: bc.SourceMap}
 	mainFrame := NewFrame(&object.Closure{Fn: mainFn}, 0)
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
@@ -59,6 +90,7 @@ func New(bc *compiler.Bytecode) *VM {
 		globals:     make([]object.Object, GlobalSize),
 		frames:      frames,
 		framesIndex: 1,
+		builtins:    object.DefaultRegistry(),
 	}
 }
 
@@ -86,19 +118,143 @@ func (vm *VM) LastPoppedElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
-// Run iterates thru the slice of bytecode instructions and executes them
+// SetBuiltins sets the registry OpGetBuiltin loads builtins from
+// it has to be the registry the bytecode was compiled with, see compiler.NewWithBuiltins
+func (vm *VM) SetBuiltins(r *object.Registry) {
+	vm.builtins = r
+}
+
+// SetCheckedArithmetic makes integer arithmetic overflowing int64 an error instead of wrapping around
+func (vm *VM) SetCheckedArithmetic(checked bool) {
+	vm.checked = checked
+}
+
+// SetLimits sets the limits applied to the following runs
+func (vm *VM) SetLimits(l Limits) {
+	vm.limits = l
+}
+
+// Run executes the bytecode without a context, only bound by the limits set
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext iterates thru the slice of bytecode instructions and executes them until done or ctx is canceled
+// errors are returned as a *RuntimeError holding the call stack at the failing instruction
+// a run stopped by ctx, the timeout or the instruction budget wraps ErrCanceled or ErrBudgetExceeded
+func (vm *VM) RunContext(ctx context.Context) error {
+	defer vm.begin(ctx)()
+	return vm.run(0)
+}
+
+// begin starts a run bound by ctx, the timeout & a fresh instruction budget, the returned func ends it
+func (vm *VM) begin(ctx context.Context) func() {
+	cancel := func() {}
+	if vm.limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, vm.limits.Timeout)
+	}
+	vm.ctx = ctx
+	vm.steps = 0
+	vm.scheduleCheck()
+	vm.running = true
+	return func() {
+		cancel()
+		vm.running = false
+	}
+}
+
+// Call calls a closure or builtin with args & runs it to completion, returning its result
+// it can be used after Run has finished, e.g. to call functions defined by a script,
+// or by a builtin to call back into the running program
+// outside of a run the call is a run of its own, with the limits set
+// a function returning an error object fails the call with it
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	if !vm.running {
+		defer vm.begin(context.Background())()
+	}
+
+	base, depth := vm.sp, vm.framesIndex
+	if err := vm.push(fn); err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			vm.sp = base
+			return nil, err
+		}
+	}
+
+	err := vm.executeFnCall(len(args))
+	if err != nil {
+		err = vm.newRuntimeError(err, code.OpCall, vm.currentFrame().ip)
+	} else if vm.framesIndex > depth {
+		err = vm.run(depth)
+	}
+	if err != nil {
+		// unwind whatever the failed call left behind
+		vm.closeUpvalues(base)
+		vm.framesIndex = depth
+		vm.sp = base
+		return nil, err
+	}
+	res := vm.pop()
+	vm.sp = base
+	// an error value fails the call as it does in the evaluator, e.g. map(arr, len) on integers
+	if errObj, ok := res.(*object.Error); ok {
+		return nil, errObj
+	}
+	return res, nil
+}
+
+// scheduleCheck sets the step at which the limits are checked next
+// so the dispatch loop only compares two integers per instruction
+func (vm *VM) scheduleCheck() {
+	next := int64(math.MaxInt64)
+	if vm.ctx.Done() != nil {
+		next = vm.steps + checkInterval
+	}
+	if limit := vm.limits.MaxInstructions; limit > 0 && limit+1 < next {
+		next = limit + 1
+	}
+	vm.nextCheck = next
+}
+
+func (vm *VM) checkLimits() error {
+	if limit := vm.limits.MaxInstructions; limit > 0 && vm.steps > limit {
+		return fmt.Errorf("%w: executed %d instructions", ErrBudgetExceeded, limit)
+	}
+	if err := vm.ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	}
+	vm.scheduleCheck()
+	return nil
+}
+
+// run executes instructions until the frame at depth returns, or the main frame runs out of instructions
+func (vm *VM) run(depth int) (err error) {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	defer func() {
+		if err != nil {
+			err = vm.newRuntimeError(err, op, ip)
+		}
+	}()
+
+	for vm.framesIndex > depth && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
 
+		if vm.steps++; vm.steps >= vm.nextCheck {
+			if err := vm.checkLimits(); err != nil {
+				return err
+			}
+		}
+
 		switch op {
 		case code.OpConstant:
 			constIndex := code.ReadUint16(ins[ip+1:])
@@ -106,11 +262,11 @@ func (vm *VM) Run() error {
 			if err := vm.push(vm.constants[constIndex]); err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
 			if err := vm.executeBinaryOperation(op); err != nil {
 				return err
 			}
-		case code.OpGreaterThan, code.OpEqual, code.OpNotEqual:
+		case code.OpGreaterThan, code.OpGreaterThanOrEqual, code.OpEqual, code.OpNotEqual:
 			if err := vm.executeCompairison(op); err != nil {
 				return err
 			}
@@ -146,6 +302,35 @@ func (vm *VM) Run() error {
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
+		case code.OpIter:
+			obj := vm.pop()
+			it, ok := object.NewIterator(obj)
+			if !ok {
+				return fmt.Errorf("type %s is not iterable", obj.Type())
+			}
+			if err := vm.push(it); err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			it := vm.stack[vm.sp-1].(*object.Iterator)
+			if item, ok := it.Next(); ok {
+				if err := vm.push(item); err != nil {
+					return err
+				}
+			} else {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpLoop:
+			vm.currentFrame().loops = append(vm.currentFrame().loops, vm.sp)
+		case code.OpUnwind:
+			loops := vm.currentFrame().loops
+			vm.sp = loops[len(loops)-1]
+		case code.OpEndLoop:
+			frame := vm.currentFrame()
+			frame.loops = frame.loops[:len(frame.loops)-1]
 		case code.OpSetGlobal:
 			i := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
@@ -178,6 +363,15 @@ func (vm *VM) Run() error {
 			if err := vm.push(arr); err != nil {
 				return err
 			}
+		case code.OpInterpolate:
+			noParts := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			str := object.Interpolate(vm.stack[vm.sp-noParts : vm.sp])
+			vm.sp -= noParts
+			if err := vm.push(str); err != nil {
+				return err
+			}
 		case code.OpHash:
 			noElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -194,12 +388,21 @@ func (vm *VM) Run() error {
 			if err := vm.executeIndexExpression(left, index); err != nil {
 				return err
 			}
+		case code.OpSetIndex:
+			op := code.Opcode(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip++
+			if err := vm.executeSetIndex(op); err != nil {
+				return err
+			}
 		case code.OpGetBuiltin:
 			// get the builtin index, push builtin fn to stack
 			builtinIndex := int(code.ReadUint8(ins[ip+1:]))
 			vm.currentFrame().ip++
-			builtin := object.Builtins[builtinIndex]
-			if err := vm.push(builtin.Builtin); err != nil {
+			builtin := vm.builtins.At(builtinIndex)
+			if builtin == nil {
+				return fmt.Errorf("undefined builtin: %d", builtinIndex)
+			}
+			if err := vm.push(builtin); err != nil {
 				return err
 			}
 		case code.OpClosure:
@@ -222,9 +425,26 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip++
 
 			currClosure := vm.currentFrame().cl
-			if err := vm.push(currClosure.Free[freeIndex]); err != nil {
+			if err := vm.push(currClosure.Free[freeIndex].Get()); err != nil {
 				return nil
 			}
+		case code.OpSetFree:
+			freeIndex := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip++
+
+			vm.currentFrame().cl.Free[freeIndex].Set(vm.pop())
+		case code.OpCaptureLocal:
+			i := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip++
+			if err := vm.push(vm.captureUpvalue(vm.currentFrame().basePointer + i)); err != nil {
+				return err
+			}
+		case code.OpCaptureFree:
+			freeIndex := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip++
+			if err := vm.push(vm.currentFrame().cl.Free[freeIndex]); err != nil {
+				return err
+			}
 		case code.OpCall:
 			noArgs := int(code.ReadUint8(ins[ip+1:]))
 			vm.currentFrame().ip++
@@ -234,9 +454,14 @@ func (vm *VM) Run() error {
 		case code.OpReturnValue:
 			// get the return value from current frame
 			retVal := vm.pop()
+			// a return in the main program ends it, its value is the last popped
+			if vm.framesIndex == 1 {
+				return nil
+			}
 
 			// pop off the current frame
 			poppedFrame := vm.popFrame()
+			vm.closeUpvalues(poppedFrame.basePointer)
 			// reset stack pointer to before call frame
 			// -1 to pop off the compiled func as well
 			vm.sp = poppedFrame.basePointer - 1
@@ -247,7 +472,15 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				if err := vm.push(Null); err != nil {
+					return err
+				}
+				vm.pop()
+				return nil
+			}
 			poppedFrame := vm.popFrame()
+			vm.closeUpvalues(poppedFrame.basePointer)
 			vm.sp = poppedFrame.basePointer - 1
 
 			if err := vm.push(Null); err != nil {
@@ -275,6 +508,13 @@ func (vm *VM) callClosure(cl *object.Closure, noArgs int) error {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumArgs, noArgs)
 	}
 
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow: maximum call depth of %d exceeded", MaxFrames)
+	}
+	if vm.sp+fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow: stack size of %d exceeded", StackSize)
+	}
+
 	// the start of the new frame needs to account for the func args already pushed onto the stack
 	newFrame := NewFrame(cl, vm.sp-noArgs)
 	vm.pushFrame(newFrame)
@@ -291,10 +531,16 @@ func (vm *VM) addClosure(fnIndex int, noFree int) error {
 	if fn, ok := constant.(*object.CompiledFunction); !ok {
 		return fmt.Errorf("not a function: %+v", constant)
 	} else {
-		free := make([]object.Object, noFree)
+		free := make([]*object.Upvalue, noFree)
 		// pop the free variables off the stack
+		// anything not captured by reference, i.e. the current closure, is wrapped in a closed upvalue
 		for i := 0; i < noFree; i++ {
-			free[i] = vm.stack[vm.sp-noFree+i]
+			switch obj := vm.stack[vm.sp-noFree+i].(type) {
+			case *object.Upvalue:
+				free[i] = obj
+			default:
+				free[i] = object.NewClosedUpvalue(obj)
+			}
 		}
 		vm.sp -= noFree
 
@@ -302,10 +548,53 @@ func (vm *VM) addClosure(fnIndex int, noFree int) error {
 	}
 }
 
+// captureUpvalue returns the open upvalue for a stack slot
+// closures capturing the same variable share a single upvalue
+func (vm *VM) captureUpvalue(slot int) *object.Upvalue {
+	for _, u := range vm.openUpvalues {
+		if u.Slot == slot {
+			return u
+		}
+	}
+	u := object.NewOpenUpvalue(&vm.stack[slot], slot)
+	vm.openUpvalues = append(vm.openUpvalues, u)
+	return u
+}
+
+// closeUpvalues closes the upvalues pointing at or above base in the stack
+// called when a frame returns so captured locals outlive it
+func (vm *VM) closeUpvalues(base int) {
+	open := vm.openUpvalues[:0]
+	for _, u := range vm.openUpvalues {
+		if u.Slot >= base {
+			u.Close()
+		} else {
+			open = append(open, u)
+		}
+	}
+	vm.openUpvalues = open
+}
+
 func (vm *VM) callBuiltinFn(builtin *object.Builtin, noArgs int) error {
 	// simply call the builtin fn with its args & push the result onto the stack
+	// in place of the builtin & its args
+	// functions called back by the builtin run on the stack above its args
 	args := vm.stack[vm.sp-noArgs : vm.sp]
-	if res := builtin.Fn(args...); res != nil {
+	var res object.Object
+	if builtin.CallFn != nil {
+		var err error
+		if res, err = builtin.CallFn(vm, args...); err != nil {
+			return err
+		}
+	} else {
+		res = builtin.Fn(args...)
+	}
+	// an error object fails the run as it does in the evaluator
+	if errObj, ok := res.(*object.Error); ok {
+		return errObj
+	}
+	vm.sp = vm.sp - noArgs - 1
+	if res != nil {
 		return vm.push(res)
 	} else {
 		return vm.push(Null)
@@ -337,28 +626,66 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	if rightType == object.INTEGER_OBJ && leftType == object.INTEGER_OBJ {
 		return vm.executeBinaryIntegerOperation(op, left, right)
 	}
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeBinaryFloatOperation(op, object.ToFloat(left), object.ToFloat(right))
+	}
 	if rightType == object.STRING_OBJ && leftType == object.STRING_OBJ {
 		return vm.executeBinaryStringOperation(op, left, right)
 	}
 	return fmt.Errorf("unsupported types for binary operation: %s, %s", leftType, rightType)
 }
 
+// overflowOperators are the symbols of the integer operations that can overflow, for their errors
+var overflowOperators = map[code.Opcode]string{code.OpAdd: "+", code.OpSub: "-", code.OpMul: "*", code.OpDiv: "/"}
+
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left object.Object, right object.Object) error {
 	rightValue := right.(*object.Integer).Value
 	leftValue := left.(*object.Integer).Value
 
+	var res int64
+	ok := true
 	switch op {
 	case code.OpAdd:
-		return vm.push(&object.Integer{Value: leftValue + rightValue})
+		res, ok = object.CheckedAdd(leftValue, rightValue)
 	case code.OpSub:
-		return vm.push(&object.Integer{Value: leftValue - rightValue})
+		res, ok = object.CheckedSub(leftValue, rightValue)
 	case code.OpMul:
-		return vm.push(&object.Integer{Value: leftValue * rightValue})
+		res, ok = object.CheckedMul(leftValue, rightValue)
 	case code.OpDiv:
-		return vm.push(&object.Integer{Value: leftValue / rightValue})
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		res, ok = object.CheckedDiv(leftValue, rightValue)
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		res = leftValue % rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
+	if !ok && vm.checked {
+		return fmt.Errorf("integer overflow: %d %s %d", leftValue, overflowOperators[op], rightValue)
+	}
+	return vm.push(&object.Integer{Value: res})
+}
+
+// executeBinaryFloatOperation handles float operands as well as mixed integer & float operands promoted to float
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, leftValue float64, rightValue float64) error {
+	switch op {
+	case code.OpAdd:
+		return vm.push(&object.Float{Value: leftValue + rightValue})
+	case code.OpSub:
+		return vm.push(&object.Float{Value: leftValue - rightValue})
+	case code.OpMul:
+		return vm.push(&object.Float{Value: leftValue * rightValue})
+	case code.OpDiv:
+		return vm.push(&object.Float{Value: leftValue / rightValue})
+	case code.OpMod:
+		return vm.push(&object.Float{Value: math.Mod(leftValue, rightValue)})
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left object.Object, right object.Object) error {
@@ -377,15 +704,24 @@ func (vm *VM) executeCompairison(op code.Opcode) error {
 	if right.Type() == object.INTEGER_OBJ && left.Type() == object.INTEGER_OBJ {
 		return vm.compareIntegers(left, right, op)
 	}
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.compareFloats(object.ToFloat(left), object.ToFloat(right), op)
+	}
 
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToObject(left == right))
+		return vm.push(nativeBoolToObject(object.Equal(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToObject(left != right))
-	default:
+		return vm.push(nativeBoolToObject(!object.Equal(left, right)))
+	}
+	c, ok := object.Compare(left, right)
+	if !ok {
 		return fmt.Errorf("unknown operator: %d, %s %s", op, left.Type(), right.Type())
 	}
+	if op == code.OpGreaterThan {
+		return vm.push(nativeBoolToObject(c > 0))
+	}
+	return vm.push(nativeBoolToObject(c >= 0))
 }
 
 func (vm *VM) compareIntegers(left object.Object, right object.Object, op code.Opcode) error {
@@ -395,6 +731,23 @@ func (vm *VM) compareIntegers(left object.Object, right object.Object, op code.O
 	switch op {
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToObject(leftVal > rightVal))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToObject(leftVal >= rightVal))
+	case code.OpEqual:
+		return vm.push(nativeBoolToObject(leftVal == rightVal))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToObject(leftVal != rightVal))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) compareFloats(leftVal float64, rightVal float64, op code.Opcode) error {
+	switch op {
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToObject(leftVal > rightVal))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToObject(leftVal >= rightVal))
 	case code.OpEqual:
 		return vm.push(nativeBoolToObject(leftVal == rightVal))
 	case code.OpNotEqual:
@@ -413,11 +766,17 @@ func nativeBoolToObject(nativeBool bool) object.Object {
 
 func (vm *VM) executeMinus() error {
 	obj := vm.pop()
+	if f, ok := obj.(*object.Float); ok {
+		return vm.push(&object.Float{Value: -f.Value})
+	}
 	if obj.Type() != object.INTEGER_OBJ {
 		return fmt.Errorf("unsupported type for negation: %s", obj.Type())
 	}
 
 	val := obj.(*object.Integer).Value
+	if val == math.MinInt64 && vm.checked {
+		return fmt.Errorf("integer overflow: -(%d)", val)
+	}
 	return vm.push(&object.Integer{Value: -val})
 }
 
@@ -454,7 +813,7 @@ func (vm *VM) buildArray(noElements int) object.Object {
 
 func (vm *VM) buildHash(noElements int) (object.Object, error) {
 	start, end := vm.sp-noElements, vm.sp
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash(noElements / 2)
 
 	for i := start; i < end; i += 2 {
 		key := vm.stack[i]
@@ -463,10 +822,10 @@ func (vm *VM) buildHash(noElements int) (object.Object, error) {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
 		val := vm.stack[i+1]
-		pairs[hashKey.Hash()] = object.HashPair{Key: key, Value: val}
+		hash.Set(hashKey, val)
 	}
 	vm.sp = start
-	return &object.Hash{Pairs: pairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeIndexExpression(left object.Object, index object.Object) error {
@@ -475,11 +834,63 @@ func (vm *VM) executeIndexExpression(left object.Object, index object.Object) er
 		return vm.executeArrayIndex(left, index)
 	case object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case object.STRING_OBJ:
+		return vm.executeStringIndex(left, index)
+	case object.MODULE_OBJ:
+		member, err := left.(*object.Module).Member(index)
+		if err != nil {
+			return err
+		}
+		return vm.push(member)
 	default:
 		return fmt.Errorf("Unsupported index operation %s", left.Type())
 	}
 }
 
+// executeSetIndex pops the value, index & container off the stack & stores the value in the container
+// a non zero op combines the current element with the value first
+// the stored value is pushed back as the result of the assignment
+func (vm *VM) executeSetIndex(op code.Opcode) error {
+	val := vm.pop()
+	index := vm.pop()
+	left := vm.pop()
+
+	if op != 0 {
+		if err := vm.executeIndexExpression(left, index); err != nil {
+			return err
+		}
+		if err := vm.push(val); err != nil {
+			return err
+		}
+		if err := vm.executeBinaryOperation(op); err != nil {
+			return err
+		}
+		val = vm.pop()
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		indexObj, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		idx := int(indexObj.Value)
+		if idx < 0 || idx >= len(left.Elements) {
+			return fmt.Errorf("index out of range: %d", idx)
+		}
+		left.Elements[idx] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Set(key, val)
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+	return vm.push(val)
+}
+
 func (vm *VM) executeArrayIndex(left object.Object, index object.Object) error {
 	// convert to array
 	arr := left.(*object.Array)
@@ -495,18 +906,30 @@ func (vm *VM) executeArrayIndex(left object.Object, index object.Object) error {
 	return vm.push(arr.Elements[idx])
 }
 
+// executeStringIndex pushes the rune at the index as a string, null if it is out of range
+func (vm *VM) executeStringIndex(left object.Object, index object.Object) error {
+	indexObj, ok := index.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("string index must be INTEGER, got %s", index.Type())
+	}
+	if s := left.(*object.String).Index(indexObj.Value); s != nil {
+		return vm.push(s)
+	}
+	return vm.push(Null)
+}
+
 func (vm *VM) executeHashIndex(left object.Object, index object.Object) error {
 	// check if it can be hashed
 	hash := left.(*object.Hash)
 	hashObj, ok := index.(object.Hashable)
 	if !ok {
-		return fmt.Errorf("object %s is not hashable", left.Type())
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
 	// check for index error
-	pair, ok := hash.Pairs[hashObj.Hash()]
+	val, ok := hash.Get(hashObj)
 	if !ok {
 		return vm.push(Null)
 	}
 	// push obj to stack
-	return vm.push(pair.Value)
+	return vm.push(val)
 }
@@ -1,13 +1,18 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -26,7 +31,16 @@ func runVMTests(t *testing.T, tests []vmTestCase) {
 		}
 
 		vm := New(comp.Bytecode())
-		if err := vm.Run(); err != nil {
+		err := vm.Run()
+		// an error object expected from a builtin fails the run
+		if expected, ok := tt.expected.(*object.Error); ok {
+			var rerr *RuntimeError
+			if !errors.As(err, &rerr) || rerr.Message != expected.Message {
+				t.Errorf("wrong error for %q, want=%q, got=%v", tt.input, expected.Message, err)
+			}
+			continue
+		}
+		if err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
 
@@ -35,6 +49,21 @@ func runVMTests(t *testing.T, tests []vmTestCase) {
 	}
 }
 
+// runInspect runs vm & inspects the last popped value, a builtin failing the run
+// reads "Error: msg" as the evaluator prints it
+func runInspect(vm *VM) (string, error) {
+	var rerr *RuntimeError
+	if err := vm.Run(); errors.As(err, &rerr) {
+		if _, ok := rerr.Err.(*object.Error); ok {
+			return "Error: " + rerr.Message, nil
+		}
+		return "", err
+	} else if err != nil {
+		return "", err
+	}
+	return vm.LastPoppedElem().Inspect(), nil
+}
+
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
 	t.Helper()
 
@@ -43,6 +72,10 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err := testIntegerObject(int64(expected), actual); err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		if err := testFloatObject(expected, actual); err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		if err := testBooleanObject(bool(expected), actual); err != nil {
 			t.Errorf("testBooleanObject failed: %s", err)
@@ -73,13 +106,13 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if !ok {
 			t.Errorf("object is not hash, got %T (%+v)", actual, actual)
 		}
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			expectedValue, ok := expected[pair.Key.(object.Hashable).Hash()]
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
 			}
@@ -111,6 +144,19 @@ func parse(input string) *ast.Program {
 	return p.ParseProgram()
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+	return nil
+}
+
 func testIntegerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 	if !ok {
@@ -163,7 +209,17 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"5 * 2 + 10", 20},
 		{"5 + 2 * 10", 25},
 		{"5 * (2 + 10)", 60},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"1 + 7 % 3 * 2", 3},
+		{"7.5 % 2", 1.5},
 		{"1 < 2", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{"1 <= 0.5", false},
 		{"1 > 2", false},
 		{"1 < 1", false},
 		{"1 > 1", false},
@@ -188,6 +244,32 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"1.5e3", 1500.0},
+		{"0.5 + 0.25", 0.75},
+		{"1.5 * 2", 3.0},
+		{"2 * 1.5", 3.0},
+		{"7 / 2.0", 3.5},
+		{"7 / 2", 3},
+		{"1 - 0.5", 0.5},
+		{"-2.5", -2.5},
+		{"-(1 - 3.5)", 2.5},
+		{"1.5 > 1", true},
+		{"1 < 1.5", true},
+		{"2.0 == 2", true},
+		{"2.5 != 2.5", false},
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{`int("42")`, 42},
+		{"float(3)", 3.0},
+		{`float("0.25")`, 0.25},
+		{"float(1) / 4 * 100", 25.0},
+	}
+	runVMTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -203,6 +285,75 @@ func TestBooleanExpressions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3, true: 4}`, "{b: 1, a: 2, 3: 3, true: 4}"},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, "{b: 4, a: 2, c: 3}"},
+		{`let keys = ""; for (k in {"z": 1, "y": 2, "x": 3}) { keys += k }; keys`, "zyx"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s, want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestValueComparison(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"mon" + "key" == "monkey"`, true},
+		{`"a" != "b"`, true},
+		{`"a" < "b"`, true},
+		{`"abc" > "abd"`, false},
+		{`"b" >= "abc"`, true},
+		{`"a" <= "a"`, true},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [1, 2, 3]", false},
+		{"[1, 2] != [2, 1]", true},
+		{`{"a": [1], 2: true} == {2: true, "a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"1 == 1.0", true},
+		{`1 == "1"`, false},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+		{"let a = [1]; a[0] = a; let b = [1]; b[0] = b; a == b", true},
+		{`let key = "mon"; {"monkey": 1}[key + "key"]`, 1},
+	}
+	runVMTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"true || false", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"if (false) { 1 } || 0", true},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"false || 1 > 2 && true", false},
+		// the right operand is only evaluated if needed
+		{"let n = 0; let f = fn() { n += 1; true }; false && f(); true || f(); n", 0},
+		{"let n = 0; let f = fn() { n += 1; true }; true && f(); false || f(); n", 2},
+		{"let x = 5; x %= 3; x", 2},
+	}
+	runVMTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -237,6 +388,17 @@ func TestStringExpressions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`let name = "monkey"; "hello ${name}!"`, "hello monkey!"},
+		{`"${1 + 2}${[1, "a"]}${true}"`, "3[1, a]true"},
+		{`let items = [1, 2]; "${len(items)} items, ${ {"n": "${items[0]}"}["n"] } first"`, "2 items, 1 first"},
+		{`let f = fn(x) { "<${x}>" }; f(f("a"))`, "<<a>>"},
+		{`"\${x}"`, "${x}"},
+	}
+	runVMTests(t, tests)
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -281,6 +443,7 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{`{"a": 1, "b": 2, "a": 3}["a"]`, 3},
 	}
 	runVMTests(t, tests)
 }
@@ -331,8 +494,18 @@ func TestFunctionsWithReturnStatement(t *testing.T) {
 			`,
 			expected: 99,
 		},
+		// a return in the main program ends it
+		{`return 10; 9;`, 10},
+		{`let x = 1; if (x > 0) { return x * 2; } 3;`, 2},
+		{`while (true) { return 5; }`, 5},
 	}
 	runVMTests(t, tests)
+
+	vm := New(&compiler.Bytecode{Instructions: code.Make(code.OpReturn)})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, Null, vm.LastPoppedElem())
 }
 
 func TestFunctionsWithoutReturnValue(t *testing.T) {
@@ -492,15 +665,15 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn() { 1; }(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `1:1: wrong number of arguments: want=0, got=1`,
 		},
 		{
 			input:    `fn(a) { a; }();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `1:1: wrong number of arguments: want=1, got=0`,
 		},
 		{
 			input:    `fn(a, b) { a + b; }(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `1:1: wrong number of arguments: want=2, got=1`,
 		},
 	}
 	for _, tt := range tests {
@@ -566,6 +739,350 @@ func TestBuiltinFunctions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`let k = 10; map([1, 2], fn(x) { x + k })`, []int{11, 12}},
+		{`map([[1], [1, 2]], len)`, []int{1, 2}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, []int{2, 4}},
+		{`filter([1, false, 0, if (false) { 1 }], fn(x) { x })`, []int{1, 0}},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)`, 16},
+		{`reduce([1, 2, 3], fn(acc, x) { acc * x })`, 6},
+		{`reduce([], fn(acc, x) { acc + x }, 0)`, 0},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`sort([3, 1, 2], fn(a, b) { b - a })`, []int{3, 2, 1}},
+		{`sort(["b", "c", "a"])[0]`, "a"},
+		// stable, equal elements keep their order
+		{`map(sort([[2, 1], [1, 2], [2, 3], [1, 4]], fn(a, b) { a[0] < b[0] }), last)`, []int{2, 4, 1, 3}},
+		{`let xs = [2, 1]; sort(xs); xs`, []int{2, 1}},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{`any([], fn(x) { true })`, false},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`if (any([1], fn(x) { false })) { 1 } else { 2 }`, 2},
+		{`find([1, 2, 3, 4], fn(x) { x > 2 })`, 3},
+		{`find([1, 2], fn(x) { x > 2 })`, Null},
+		// stops at the first match
+		{`let n = 0; any([1, 2, 3], fn(x) { n += 1; x == 2 }); n`, 2},
+		{`let f = fn(xs) { map(xs, fn(x) { reduce(x, fn(a, b) { a + b }, 0) }) }; f([[1, 2], [3]])`, []int{3, 3}},
+		{`reduce([], fn(acc, x) { acc + x })`, &object.Error{Message: "reduce of empty array with no initial value"}},
+		{`sort([1, "a"])`, &object.Error{Message: "cannot compare STRING with INTEGER"}},
+		{`sort([1, 2], fn(a, b) { "a" })`, &object.Error{Message: "comparator must return a BOOLEAN or a number, got STRING"}},
+		{`map(1, fn(x) { x })`, &object.Error{Message: "method expected an array, got *object.Integer"}},
+		{`filter([1])`, &object.Error{Message: "wrong number of arguments. got=1, want=2"}},
+	}
+	runVMTests(t, tests)
+}
+
+func TestHigherOrderBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2], fn(x) { x + "s" })`, "1:21: unsupported types for binary operation: INTEGER, STRING"},
+		{`map([1], fn(a, b) { a })`, "1:1: wrong number of arguments: want=2, got=1"},
+		{`sort([2, 1], fn(a, b) { -"x" })`, "1:25: unsupported type for negation: STRING"},
+		{`map([1], 2)`, "1:1: Not a callable or builtin function"},
+		// an error value returned to the builtin fails it as in the evaluator
+		{`map([1, 2], len)`, "1:1: argument to `len` not supported, got INTEGER"},
+		{`filter([1], fn(x) { len(x) })`, "1:21: argument to `len` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q, want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// the trace runs through the builtin into the failing callback
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { x + \"s\" };\nmap([1], f);")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var rerr *RuntimeError
+	if err := New(comp.Bytecode()).Run(); !errors.As(err, &rerr) {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if len(rerr.Trace) != 2 || rerr.Trace[0].Pos.String() != "2:1" || rerr.Trace[1].Function != "f" {
+		t.Errorf("wrong trace: %+v", rerr.Trace)
+	}
+
+	// limits still apply to functions called back by a builtin
+	comp = compiler.New()
+	if err := comp.Compile(parse("map([1], fn(x) { while (true) {} })")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetLimits(Limits{MaxInstructions: 1000})
+	if err := vm.Run(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got %v", err)
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len({"a": 1, "b": 2})`, "2"},
+		{`keys({"b": 1, "a": 2, 3: 3})`, "[b, a, 3]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`entries({"b": 1, "a": 2})`, "[[b, 1], [a, 2]]"},
+		{`entries({})`, "[]"},
+		{`has({"a": if (false) { 1 }}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+		{`delete({"a": 1}, "b")`, "{a: 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+		{`merge({"a": 1, "b": 2}, {"b": 3, "c": 4}, {"a": 5})`, "{a: 5, b: 3, c: 4}"},
+		{`let h = {"a": 1}; merge(h, {"b": 2}); h`, "{a: 1}"},
+		{`let h = {"a": 1}; let m = merge(h); m["b"] = 2; h`, "{a: 1}"},
+		{`keys([1])`, "Error: argument to `keys` must be HASH, got ARRAY"},
+		{`has({}, [1])`, "Error: unusable as hash key: ARRAY"},
+		{`delete({})`, "Error: wrong number of arguments. got=1, want=2"},
+		{`merge({}, 1)`, "Error: argument to `merge` must be HASH, got INTEGER"},
+		{`merge()`, "Error: wrong number of arguments. got=0, want at least 1"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		got, err := runInspect(New(comp.Bytecode()))
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("héllo")`, 5},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"héllo"[5]`, Null},
+		{`"abc"[-1]`, Null},
+		{`split("a,b,,c", ",")[3]`, "c"},
+		{`len(split("a,b,,c", ","))`, 4},
+		{`len(split("añb", ""))`, 3},
+		{`join(["a", 1, true], "-")`, "a-1-true"},
+		{`join([], ",")`, ""},
+		{`trim(" \t a b \n")`, "a b"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ABC")`, "abc"},
+		{`contains("monkey", "key")`, true},
+		{`startsWith("monkey", "mon")`, true},
+		{`endsWith("monkey", "mon")`, false},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`indexOf("héllo", "l")`, 2},
+		{`indexOf("héllo", "x")`, -1},
+		{`substr("héllo", 1, 3)`, "éll"},
+		{`substr("héllo", 2)`, "llo"},
+		{`substr("héllo", 4, 10)`, "o"},
+		{`substr("héllo", 9)`, ""},
+		{`repeat("ab", 3)`, "abab" + "ab"},
+		{`repeat("ab", 0)`, ""},
+		{`chars("añb")[1]`, "ñ"},
+		{`format("{} + {} = {}", 1, 2.5, "x")`, "1 + 2.5 = x"},
+		{`format("{{}} {}", [1])`, "{} [1]"},
+		{`let n = 0; for (c in "añb") { n += 1 }; n`, 3},
+		{`upper(1)`, &object.Error{Message: "argument 1 to `upper` must be STRING, got INTEGER"}},
+		{`contains("a")`, &object.Error{Message: "wrong number of arguments. got=1, want=2"}},
+		{`substr("abc", -1)`, &object.Error{Message: "negative position in `substr`: -1, 9223372036854775807"}},
+		{`repeat("a", -1)`, &object.Error{Message: "negative count in `repeat`: -1"}},
+		{`repeat("ab", 9223372036854775807)`, &object.Error{Message: "result of `repeat` too long"}},
+		{`format("{}")`, &object.Error{Message: "too few arguments to `format`, got 0"}},
+		{`format("{}", 1, 2)`, &object.Error{Message: "too many arguments to `format`, got 2, used 1"}},
+		{`format("{ }", 1)`, &object.Error{Message: "unmatched { in format"}},
+	}
+	runVMTests(t, tests)
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		checked  bool
+		expected string // the error, empty if the run succeeds
+	}{
+		{"1 / 0", false, "1:1: division by zero"},
+		{"let f = fn(x) { 1 % x }; f(0)", false, "1:17: modulo by zero"},
+		{"let x = 1; x /= 0", false, "1:12: division by zero"},
+		{"1.0 / 0", false, ""},
+		{"9223372036854775807 + 1", false, ""},
+		{"9223372036854775807 + 1", true, "1:1: integer overflow: 9223372036854775807 + 1"},
+		{"-9223372036854775807 - 2", true, "1:1: integer overflow: -9223372036854775807 - 2"},
+		{"4611686018427387904 * 2", true, "1:1: integer overflow: 4611686018427387904 * 2"},
+		{"let m = -9223372036854775807 - 1; m / -1", true, "1:35: integer overflow: -9223372036854775808 / -1"},
+		{"let m = -9223372036854775807 - 1; -m", true, "1:35: integer overflow: -(-9223372036854775808)"},
+		{"9223372036854775806 + 1; -4611686018427387904 * 2", true, ""},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetCheckedArithmetic(tt.checked)
+		err := vm.Run()
+		switch {
+		case tt.expected == "" && err != nil:
+			t.Errorf("unexpected error for %q: %s", tt.input, err)
+		case tt.expected != "" && (err == nil || err.Error() != tt.expected):
+			t.Errorf("wrong error for %q, want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`abs(-3)`, 3},
+		{`abs(-2.5)`, 2.5},
+		{`min(3, 1, 2)`, 1},
+		{`min(2, 1.5)`, 1.5},
+		{`max(1, 3, 3.0)`, 3},
+		{`pow(2, 10)`, 1024},
+		{`pow(-3, 3)`, -27},
+		{`pow(2, -1)`, 0.5},
+		{`pow(4, 0.5)`, 2.0},
+		{`sqrt(16)`, 4.0},
+		{`floor(2.7)`, 2},
+		{`floor(-2.5)`, -3},
+		{`ceil(2.1)`, 3},
+		{`ceil(5)`, 5},
+		{`clamp(5, 0, 3)`, 3},
+		{`clamp(-1, 0, 3)`, 0},
+		{`clamp(1.5, 0, 3)`, 1.5},
+		{`seed(7); let a = [random(), randomInt(100)]; seed(7); a == [random(), randomInt(100)]`, true},
+		{`let ok = true; for (i in [1, 2, 3, 4, 5, 6, 7, 8]) { let n = randomInt(3, 5); ok = ok && n >= 3 && n < 5 }; ok`, true},
+		{`let r = random(); r >= 0 && r < 1`, true},
+		{`abs(-9223372036854775807 - 1)`, &object.Error{Message: "integer overflow: abs(-9223372036854775808)"}},
+		{`pow(10, 19)`, &object.Error{Message: "integer overflow: pow(10, 19)"}},
+		{`floor(1.0 / 0)`, &object.Error{Message: "cannot convert +Inf to INTEGER"}},
+		{`clamp(1, 3, 0)`, &object.Error{Message: "invalid range to `clamp`: 3 to 0"}},
+		{`min("a")`, &object.Error{Message: "argument 1 to `min` must be a number, got STRING"}},
+		{`randomInt(0)`, &object.Error{Message: "invalid range to `randomInt`: 0 to 0"}},
+	}
+	runVMTests(t, tests)
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`json.parse("{\"b\": [1, 2.5, \"\\u00e9\"], \"a\": {\"n\": null, \"t\": true}}")`, "{b: [1, 2.5, é], a: {n: null, t: true}}"},
+		{`json.parse(" 7 ")`, "7"},
+		{`json.parse("1.0") == 1.0`, "true"},
+		{`json.parse("92233720368547758070")`, "9.223372036854776e+19"},
+		{`json.parse("{\"a\": 1, \"a\": 2}")`, "{a: 2}"},
+		{`let h = json.parse("{\"z\": 1, \"a\": 2}"); keys(h)`, "[z, a]"},
+		{`json.stringify({"b": [1, 2.5, "q\"\n<"], "a": {"n": if (false) { 1 }, "t": true}})`, `{"b":[1,2.5,"q\"\n<"],"a":{"n":null,"t":true}}`},
+		{`json.stringify([1.0, {1: 2, true: "x"}])`, `[1.0,{"1":2,"true":"x"}]`},
+		{`json.stringify({"a": [1]}, 2)`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{`json.stringify([], "\t")`, "[]"},
+		{`let s = "{\"k\":[true,null,\"v\"]}"; json.stringify(json.parse(s)) == s`, "true"},
+		{`json.parse("[1, x]")`, "Error: json.parse: invalid character 'x' looking for beginning of value at offset 4"},
+		{`json.parse("[1, 2")`, "Error: json.parse: unexpected end of input at offset 5"},
+		{`json.parse("")`, "Error: json.parse: unexpected end of input at offset 0"},
+		{`json.parse("1  2")`, "Error: json.parse: unexpected data after the value at offset 3"},
+		{`json.parse("[1e400]")`, "Error: json.parse: number 1e400 out of range at offset 1"},
+		{`json.parse(1)`, "Error: argument 1 to `json.parse` must be STRING, got INTEGER"},
+		{`json.stringify([fn(x) { x }])`, "Error: json.stringify: cannot encode a value of type CLOSURE"},
+		{`json.stringify({"f": len})`, "Error: json.stringify: cannot encode a value of type BUILTIN"},
+		{`json.stringify(0.0 / 0)`, "Error: json.stringify: cannot encode NaN"},
+		{`let a = [1]; a[0] = a; json.stringify(a)`, "Error: json.stringify: cannot encode a cyclic ARRAY"},
+		{`let a = [1]; json.stringify([a, a])`, "[[1],[1]]"},
+		{`json.stringify(1, -1)`, "Error: json.stringify: indent must be 0 to 10 spaces, got -1"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		got, err := runInspect(New(comp.Bytecode()))
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestMembers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`json`, "module json"},
+		{`json["parse"]("[1]")`, "[1]"},
+		{`let j = json; j.stringify({"a": 1})`, `{"a":1}`},
+		{`let h = {"a": {"b": 2}}; h.a.b`, "2"},
+		{`{"a": 1}.b`, "null"},
+		// errors
+		{`json.nope`, "1:1: module json has no member nope"},
+		{`json[1]`, "1:1: module json has no member 1"},
+		{`json["parse"] = 1`, "1:1: index assignment not supported: MODULE"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		got := ""
+		if err := vm.Run(); err != nil {
+			got = err.Error()
+		} else {
+			got = vm.LastPoppedElem().Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCyclicInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1]; a[0] = a; a`, "[[...]]"},
+		{`let h = {"k": 1}; h["self"] = h; h`, "{k: 1, self: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; [a, h]`, "[[{a: [...]}], {a: [{...}]}]"},
+		{`let a = [1]; a[0] = a; "${a}"`, "[[...]]"},
+		{`let b = [1]; [b, b]`, "[[1], [1]]"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		got, err := runInspect(New(comp.Bytecode()))
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %q, want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -694,3 +1211,379 @@ func TestRecursiveFunctions(t *testing.T) {
 	}
 	runVMTests(t, tests)
 }
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let r = 0; while (true) { let r = 5; break; } r", 5},
+		{"while (false) { 1 }; 2", 2},
+		{"let r = 0; for (x in [1, 2, 3]) { let r = x; } r", 3},
+		{"let r = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let r = x; } r", 2},
+		{"let r = 0; for (x in [1, 2, 3]) { if (x == 3) { continue; } let r = x; } r", 2},
+		{"for (x in []) { 1 }; 7", 7},
+		{`let r = ""; for (c in "héllo") { let r = c; } r`, "o"},
+		{`let r = ""; for (c in "hé") { let r = c; } r`, "é"},
+		{`let r = 0; for (k in {"a": 1}) { let r = k; } r`, "a"},
+		{
+			"let r = 0; for (x in [1, 2]) { for (y in [10, 20]) { if (y == 20) { break; } let r = x + y; } } r",
+			12,
+		},
+		{"let f = fn(arr) { for (x in arr) { if (x > 1) { return x; } } return 0; }; f([1, 5, 3])", 5},
+		{"let f = fn(arr) { for (x in arr) { let y = x; } }; f([1, 2])", Null},
+		{"if (true) { let a = 1; }", Null},
+	}
+	runVMTests(t, tests)
+}
+
+// break, continue & return inside an expression drop the operands pending on the stack
+func TestLoopControlInExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`let i = 0; while (i < 5000) { i = i + 1; len(if (true) { continue; } else { "a" }) } i`, 5000},
+		{"let i = 0; while (true) { i = i + 1; [1, 2, if (i == 5000) { break; }] } i", 5000},
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + [x, if (x == 2) { break; }][0] } s", 1},
+		{"let n = 0; while (true) { (if (true) { break; } else { 1 }) + (n = n + 1) } n", 0},
+		{
+			"let f = fn() { let s = 0; for (x in [1, 2, 3]) { for (y in [1, 2]) { s += x * (if (y == 2) { continue; } else { y }) } } s }; f()",
+			6,
+		},
+		{`let f = fn() { len(if (true) { return 7; } else { "a" }) }; f()`, 7},
+		// builtin calls inside a loop leave nothing behind on the stack
+		{`let i = 0; let n = 0; while (i < 5000) { i = i + 1; n = n + len("a") } n`, 5000},
+	}
+	runVMTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let i = 0; while (i < 5) { i += 1; } i", 5},
+		{"let f = fn() { let x = 1; x += 2; x }; f()", 3},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr", []int{1, 5, 3}},
+		{"let arr = [1, 2, 3]; arr[2] *= 10", 30},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"]`, 5},
+		// closures share the captured binding
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()", 3},
+		{"let f = fn() { let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n }; f()", 2},
+		{"let f = fn() { let n = 0; let get = fn() { n }; n = 7; get() }; f()", 7},
+		{
+			"let f = fn() { let n = 0; let a = fn() { fn() { n += 1 } }; let b = fn() { n }; a()(); a()(); b() }; f()",
+			2,
+		},
+		{
+			"let mk = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let p = mk(); p[0](); p[0](); p[1]()",
+			2,
+		},
+	}
+	runVMTests(t, tests)
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[3] = 1", "1:14: index out of range: 3"},
+		{"let a = 1; a[0] = 1", "1:12: index assignment not supported: INTEGER"},
+		{`let h = {}; h[fn() {}] = 1`, "1:13: unusable as hash key: CLOSURE"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestIteratingNonIterable(t *testing.T) {
+	program := parse("for (x in 1) { x }")
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err := vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	if expected := "1:1: type INTEGER is not iterable"; err.Error() != expected {
+		t.Errorf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	input := `let add = fn(a, b) { a + b };
+let wrap = fn(x) {
+  add(x, "s")
+};
+wrap(1);`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if rerr.Op != code.OpAdd {
+		t.Errorf("wrong opcode, want=%d, got=%d", code.OpAdd, rerr.Op)
+	}
+	if expected := "1:22: unsupported types for binary operation: INTEGER, STRING"; rerr.Error() != expected {
+		t.Errorf("wrong error, want=%q, got=%q", expected, rerr.Error())
+	}
+
+	expected := []struct {
+		function string
+		pos      string
+	}{
+		{"<main>", "5:1"},
+		{"wrap", "3:3"},
+		{"add", "1:22"},
+	}
+	if len(rerr.Trace) != len(expected) {
+		t.Fatalf("wrong trace length, want=%d, got=%d", len(expected), len(rerr.Trace))
+	}
+	for i, tt := range expected {
+		frame := rerr.Trace[i]
+		if frame.Function != tt.function {
+			t.Errorf("frame %d has wrong function, want=%q, got=%q", i, tt.function, frame.Function)
+		}
+		if frame.Pos.String() != tt.pos {
+			t.Errorf("frame %d has wrong position, want=%s, got=%s", i, tt.pos, frame.Pos)
+		}
+	}
+
+	traceback := `Traceback (most recent call last):
+  line 5, column 1, in <main>
+  line 3, column 3, in wrap
+  line 1, column 22, in add
+RuntimeError: unsupported types for binary operation: INTEGER, STRING (in OpAdd)
+`
+	if rerr.Traceback() != traceback {
+		t.Errorf("wrong traceback, want=\n%s\ngot=\n%s", traceback, rerr.Traceback())
+	}
+}
+
+func TestIndexErrorTrace(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let h = {\"a\": 1};\nh[[1]];")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	traceback := `Traceback (most recent call last):
+  line 2, column 1, in <main>
+RuntimeError: unusable as hash key: ARRAY (in OpIndex)
+`
+	if rerr.Traceback() != traceback {
+		t.Errorf("wrong traceback, want=\n%s\ngot=\n%s", traceback, rerr.Traceback())
+	}
+}
+
+func TestStackOverflowTrace(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(n) { f(n + 1) }; f(0);")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	expected := fmt.Sprintf("  line 1, column 17, in f\n  [previous line repeated %d more times]\n", len(rerr.Trace)-3)
+	if !strings.Contains(rerr.Traceback(), expected) {
+		t.Errorf("traceback does not collapse recursion:\n%s", rerr.Traceback())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	loop := "let i = 0;\nwhile (true) { i += 1; }"
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   Limits
+		expected error
+	}{
+		{"1 + 2", context.Background(), Limits{MaxInstructions: 4}, nil},
+		{"1 + 2", context.Background(), Limits{MaxInstructions: 3}, ErrBudgetExceeded},
+		{loop, context.Background(), Limits{MaxInstructions: 1000}, ErrBudgetExceeded},
+		{loop, canceled, Limits{}, ErrCanceled},
+		{loop, canceled, Limits{}, context.Canceled},
+		{loop, context.Background(), Limits{Timeout: 10 * time.Millisecond}, context.DeadlineExceeded},
+		{"1 + 2", canceled, Limits{}, nil},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err := vm.RunContext(tt.ctx)
+		if tt.expected == nil {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", tt.input, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("wrong error for %q with %+v, want=%v, got=%v", tt.input, tt.limits, tt.expected, err)
+			continue
+		}
+		// the error points at where execution stopped
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) || !rerr.Trace[len(rerr.Trace)-1].Pos.IsValid() {
+			t.Errorf("error for %q has no position: %v", tt.input, err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	input := `
+	let add = fn(a, b) { a + b };
+	let counter = fn() { let n = 0; fn() { n += 1 } }();
+	`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	add, counter := vm.globals[0], vm.globals[1]
+
+	res, err := vm.Call(add, &object.Integer{Value: 1}, &object.Integer{Value: 2})
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	testExpectedObject(t, 3, res)
+
+	for i := 1; i <= 3; i++ {
+		res, err := vm.Call(counter)
+		if err != nil {
+			t.Fatalf("call failed: %s", err)
+		}
+		testExpectedObject(t, i, res)
+	}
+
+	res, err = vm.Call(object.GetBuiltinByName("len"), &object.String{Value: "four"})
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	testExpectedObject(t, 4, res)
+
+	if _, err := vm.Call(add, &object.Integer{Value: 1}); err == nil {
+		t.Errorf("expected error calling with wrong number of arguments")
+	}
+	if _, err := vm.Call(add, &object.Integer{Value: 1}, &object.String{Value: "a"}); err == nil {
+		t.Errorf("expected error from the called function")
+	}
+	if vm.sp != 0 || vm.framesIndex != 1 {
+		t.Errorf("stack not unwound after calls, sp=%d, frames=%d", vm.sp, vm.framesIndex)
+	}
+}
+
+func TestCallLimits(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let spin = fn(n) { let i = 0; while (i < n) { i += 1; } i };")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetLimits(Limits{MaxInstructions: 1000})
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := vm.RunContext(canceled); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	spin := vm.globals[0]
+
+	// every call gets the whole budget & none of the context of the last run
+	for i := 0; i < 3; i++ {
+		res, err := vm.Call(spin, &object.Integer{Value: 50})
+		if err != nil {
+			t.Fatalf("call %d failed: %s", i, err)
+		}
+		testExpectedObject(t, 50, res)
+	}
+	if _, err := vm.Call(spin, &object.Integer{Value: 1000}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("wrong error, want=%v, got=%v", ErrBudgetExceeded, err)
+	}
+
+	vm.SetLimits(Limits{Timeout: 10 * time.Millisecond})
+	if _, err := vm.Call(spin, &object.Integer{Value: 1 << 40}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error, want=%v, got=%v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRandomPerVM(t *testing.T) {
+	run := func(input string) *VM {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		return vm
+	}
+
+	expected := run("seed(1); random()").LastPoppedElem()
+	a := run("seed(1); let f = fn() { random() };")
+	// drawing from another vm leaves the source of a as seeded
+	run("seed(2); random(); random()")
+	res, err := a.Call(a.globals[0])
+	if err != nil {
+		t.Fatalf("call failed: %s", err)
+	}
+	if !object.Equal(res, expected) {
+		t.Errorf("wrong random number, want=%s, got=%s", expected.Inspect(), res.Inspect())
+	}
+}
+
+func TestCustomBuiltins(t *testing.T) {
+	r := object.NewRegistry()
+	r.Register("answer", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 42}
+	}})
+	r.Register("len", object.GetBuiltinByName("len"))
+
+	comp := compiler.NewWithBuiltins(r)
+	if err := comp.Compile(parse(`answer() + len("ab")`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetBuiltins(r)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 44, vm.LastPoppedElem())
+
+	// builtins missing from the registry are undefined
+	comp = compiler.NewWithBuiltins(r)
+	if err := comp.Compile(parse(`print("hi")`)); err == nil {
+		t.Errorf("expected print to be undefined")
+	}
+}